@@ -1,8 +1,73 @@
 package main
 
-import "github.com/focusandinsist/go-ws-srv/internal/server"
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os/signal"
+	"syscall"
+
+	"github.com/focusandinsist/go-ws-srv/config"
+	"github.com/focusandinsist/go-ws-srv/internal/server"
+)
 
 func main() {
-	srv := server.NewServer()
-	srv.Start(":8080")
+	configPath := flag.String("config", "config/config.yaml", "配置文件路径，为空则只使用默认值和环境变量")
+	// 每个配置项都可以用同名参数覆盖，例如 -server.addr=:9090，优先级高于配置文件和环境变量
+	overrides := make(map[string]string)
+	for _, key := range config.Keys() {
+		flag.Func(key, "覆盖配置项 "+key+"（环境变量 "+config.EnvName(key)+"）", func(v string) error {
+			overrides[key] = v
+			return nil
+		})
+	}
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	for key, v := range overrides {
+		if err := cfg.Set(key, v); err != nil {
+			log.Fatalf("Invalid flag -%s: %v", key, err)
+		}
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid config:\n%v", err)
+	}
+
+	srv, err := server.NewServer(cfg)
+	if err != nil {
+		log.Fatalf("Failed to create server: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.Start(cfg.Server.Addr)
+	}()
+
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Server failed: %v", err)
+		}
+		return
+	case <-ctx.Done():
+	}
+	// 再次收到信号时直接退出
+	stop()
+
+	log.Printf("Shutting down, waiting up to %s for connections to drain...", cfg.Server.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Shutdown finished with errors: %v", err)
+		return
+	}
+	log.Println("Server stopped")
 }
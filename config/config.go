@@ -0,0 +1,273 @@
+// 配置
+// 职责：集中定义服务的配置项，各组件的构造函数从这里取参数。
+// 加载顺序：默认值 -> YAML 文件 -> WSSRV_* 环境变量 -> 命令行参数，后者覆盖前者，最后统一校验。
+package config
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// 存储和消息分发的后端类型
+const (
+	BackendMemory = "memory"
+	BackendRedis  = "redis"
+	BackendMongo  = "mongo"
+	BackendKafka  = "kafka"
+)
+
+// 多设备策略
+const (
+	DeviceKickOldest   = "kick_oldest"
+	DeviceRejectNewest = "reject_newest"
+)
+
+// 发送队列满时的策略
+const (
+	SendDropOldest     = "drop_oldest"
+	SendDropNewest     = "drop_newest"
+	SendDisconnectSlow = "disconnect_slow"
+)
+
+// Config 服务配置
+type Config struct {
+	Server     ServerConfig     `yaml:"server"`
+	Auth       AuthConfig       `yaml:"auth"`
+	Connection ConnectionConfig `yaml:"connection"`
+	Ack        AckConfig        `yaml:"ack"`
+	Delivery   DeliveryConfig   `yaml:"delivery"`
+	Storage    StorageConfig    `yaml:"storage"`
+	Broker     BrokerConfig     `yaml:"broker"`
+	Cluster    ClusterConfig    `yaml:"cluster"`
+	Presence   PresenceConfig   `yaml:"presence"`
+}
+
+// ServerConfig HTTP 服务配置
+type ServerConfig struct {
+	Addr         string        `yaml:"addr"`
+	ReadTimeout  time.Duration `yaml:"read_timeout"`
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+	// ShutdownTimeout 停机时等待连接排空的最长时间，超时后强制关闭
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// ReconnectDelay 停机时提示客户端在 [0, ReconnectDelay] 内随机等待后重连
+	ReconnectDelay time.Duration `yaml:"reconnect_delay"`
+	// TLSCertFile / TLSKeyFile 同时设置时以 HTTPS/WSS 提供服务，文件变化后自动重新加载
+	TLSCertFile string `yaml:"tls_cert_file"`
+	TLSKeyFile  string `yaml:"tls_key_file"`
+	// TLSClientCAFile 签发内部服务客户端证书的 CA，设置后可以在路由上启用 mtls 中间件
+	TLSClientCAFile string `yaml:"tls_client_ca_file"`
+	// ServiceIdentities 客户端证书 CN 到服务身份的映射，不在其中的证书会被 mtls 中间件拒绝，只能在配置文件中设置
+	ServiceIdentities map[string]string `yaml:"service_identities"`
+	// Middleware 路由分组（ws、api、health、metrics）到中间件名（logger、recovery、mtls）的映射，
+	// 未列出的分组使用默认值，只能在配置文件中设置
+	Middleware map[string][]string `yaml:"middleware"`
+}
+
+// AuthConfig JWT 认证配置，Secret / PublicKeyFile / JWKSFile 三选一
+type AuthConfig struct {
+	Secret        string        `yaml:"secret"`
+	PublicKeyFile string        `yaml:"public_key_file"`
+	JWKSFile      string        `yaml:"jwks_file"`
+	Issuer        string        `yaml:"issuer"`
+	Audience      string        `yaml:"audience"`
+	Leeway        time.Duration `yaml:"leeway"`
+}
+
+// ConnectionConfig 单个连接相关的配置
+type ConnectionConfig struct {
+	MaxDevices   int           `yaml:"max_devices"`   // <=0 表示不限制
+	DevicePolicy string        `yaml:"device_policy"` // kick_oldest 或 reject_newest
+	QueueSize    int           `yaml:"queue_size"`
+	SendPolicy   string        `yaml:"send_policy"` // drop_oldest、drop_newest 或 disconnect_slow
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+	PingInterval time.Duration `yaml:"ping_interval"`
+	PongTimeout  time.Duration `yaml:"pong_timeout"`
+	EventTimeout time.Duration `yaml:"event_timeout"` // 单个事件处理器的最长执行时间，到期后 Context 被取消
+	SlowEvent    time.Duration `yaml:"slow_event"`    // 处理耗时超过该值的事件记录慢日志，0 表示不记录
+}
+
+// AckConfig 等待 ack 的配置
+type AckConfig struct {
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// DeliveryConfig 可靠投递的重发配置
+type DeliveryConfig struct {
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+	TTL            time.Duration `yaml:"ttl"`
+}
+
+// StorageConfig 存储配置
+type StorageConfig struct {
+	Offline         string `yaml:"offline"` // 离线消息：memory 或 redis
+	History         string `yaml:"history"` // 历史消息：memory 或 mongo
+	RedisAddr       string `yaml:"redis_addr"`
+	MongoURI        string `yaml:"mongo_uri"`
+	MongoDB         string `yaml:"mongo_db"`
+	MongoCollection string `yaml:"mongo_collection"`
+}
+
+// BrokerConfig 消息分发配置
+type BrokerConfig struct {
+	Type         string   `yaml:"type"` // memory、kafka 或 redis
+	KafkaBrokers []string `yaml:"kafka_brokers"`
+	KafkaTopic   string   `yaml:"kafka_topic"`
+	// KafkaGroupPrefix 消费组 ID 的前缀，每个节点使用 前缀+节点 ID 作为自己的消费组，都能收到全部消息
+	KafkaGroupPrefix string `yaml:"kafka_group_prefix"`
+	RedisAddr        string `yaml:"redis_addr"`
+	RedisPrefix      string `yaml:"redis_prefix"` // Pub/Sub 频道前缀，同一个 Redis 上的多个集群需要使用不同的前缀
+}
+
+// ClusterConfig 集群配置
+type ClusterConfig struct {
+	// NodeID 节点 ID，集群内必须唯一；为空时启动时按主机名生成，broker 为 kafka 时必须配置
+	NodeID string `yaml:"node_id"`
+}
+
+// PresenceConfig 集群在线状态配置
+type PresenceConfig struct {
+	Type        string        `yaml:"type"` // memory 或 redis
+	RedisAddr   string        `yaml:"redis_addr"`
+	RedisPrefix string        `yaml:"redis_prefix"`
+	TTL         time.Duration `yaml:"ttl"` // 节点超过 TTL 没有心跳即视为下线，心跳间隔为 TTL 的三分之一
+}
+
+// Default 默认配置：全部使用内存实现，不依赖任何外部服务
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Addr:            ":8080",
+			ReadTimeout:     10 * time.Second,
+			WriteTimeout:    10 * time.Second,
+			ShutdownTimeout: 30 * time.Second,
+			ReconnectDelay:  5 * time.Second,
+		},
+		Connection: ConnectionConfig{
+			MaxDevices:   5,
+			DevicePolicy: DeviceKickOldest,
+			QueueSize:    256,
+			SendPolicy:   SendDropOldest,
+			WriteTimeout: 10 * time.Second,
+			PingInterval: 30 * time.Second,
+			PongTimeout:  60 * time.Second,
+			EventTimeout: 10 * time.Second,
+			SlowEvent:    time.Second,
+		},
+		Ack: AckConfig{
+			Timeout: 5 * time.Second,
+		},
+		Delivery: DeliveryConfig{
+			InitialBackoff: time.Second,
+			MaxBackoff:     30 * time.Second,
+			TTL:            2 * time.Minute,
+		},
+		Storage: StorageConfig{
+			Offline:         BackendMemory,
+			History:         BackendMemory,
+			RedisAddr:       "localhost:6379",
+			MongoURI:        "mongodb://localhost:27017",
+			MongoDB:         "chatDB",
+			MongoCollection: "messages",
+		},
+		Broker: BrokerConfig{
+			Type:             BackendMemory,
+			KafkaBrokers:     []string{"localhost:9092"},
+			KafkaTopic:       "websocket-messages",
+			KafkaGroupPrefix: "wssrv-",
+			RedisAddr:        "localhost:6379",
+			RedisPrefix:      "wssrv:",
+		},
+		Presence: PresenceConfig{
+			Type:        BackendMemory,
+			RedisAddr:   "localhost:6379",
+			RedisPrefix: "wssrv:presence:",
+			TTL:         30 * time.Second,
+		},
+	}
+}
+
+// Validate 校验配置，返回所有不合法的配置项
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Server.Addr != "", "server.addr is required")
+	check(c.Server.ReadTimeout >= 0, "server.read_timeout must not be negative")
+	check(c.Server.WriteTimeout >= 0, "server.write_timeout must not be negative")
+	check(c.Server.ShutdownTimeout > 0, "server.shutdown_timeout must be positive")
+	check(c.Server.ReconnectDelay >= 0, "server.reconnect_delay must not be negative")
+	check((c.Server.TLSCertFile == "") == (c.Server.TLSKeyFile == ""), "server.tls_cert_file and server.tls_key_file must be set together")
+	check(c.Server.TLSClientCAFile == "" || c.Server.TLSCertFile != "", "server.tls_client_ca_file requires server.tls_cert_file")
+	check(len(c.Server.ServiceIdentities) == 0 || c.Server.TLSClientCAFile != "", "server.service_identities requires server.tls_client_ca_file")
+
+	keys := 0
+	for _, k := range []string{c.Auth.Secret, c.Auth.PublicKeyFile, c.Auth.JWKSFile} {
+		if k != "" {
+			keys++
+		}
+	}
+	check(keys == 1, "exactly one of auth.secret (WSSRV_AUTH_SECRET), auth.public_key_file or auth.jwks_file is required")
+	check(c.Auth.Leeway >= 0, "auth.leeway must not be negative")
+
+	check(oneOf(c.Connection.DevicePolicy, DeviceKickOldest, DeviceRejectNewest),
+		"connection.device_policy must be %s or %s, got %q", DeviceKickOldest, DeviceRejectNewest, c.Connection.DevicePolicy)
+	check(oneOf(c.Connection.SendPolicy, SendDropOldest, SendDropNewest, SendDisconnectSlow),
+		"connection.send_policy must be %s, %s or %s, got %q", SendDropOldest, SendDropNewest, SendDisconnectSlow, c.Connection.SendPolicy)
+	check(c.Connection.QueueSize > 0, "connection.queue_size must be positive")
+	check(c.Connection.WriteTimeout > 0, "connection.write_timeout must be positive")
+	check(c.Connection.PingInterval > 0, "connection.ping_interval must be positive")
+	check(c.Connection.PongTimeout > c.Connection.PingInterval, "connection.pong_timeout must be greater than connection.ping_interval")
+	check(c.Connection.EventTimeout > 0, "connection.event_timeout must be positive")
+	check(c.Connection.SlowEvent >= 0, "connection.slow_event must not be negative")
+
+	check(c.Ack.Timeout > 0, "ack.timeout must be positive")
+
+	check(c.Delivery.InitialBackoff > 0, "delivery.initial_backoff must be positive")
+	check(c.Delivery.MaxBackoff >= c.Delivery.InitialBackoff, "delivery.max_backoff must not be less than delivery.initial_backoff")
+	check(c.Delivery.TTL > 0, "delivery.ttl must be positive")
+
+	check(oneOf(c.Storage.Offline, BackendMemory, BackendRedis),
+		"storage.offline must be %s or %s, got %q", BackendMemory, BackendRedis, c.Storage.Offline)
+	check(oneOf(c.Storage.History, BackendMemory, BackendMongo),
+		"storage.history must be %s or %s, got %q", BackendMemory, BackendMongo, c.Storage.History)
+	check(c.Storage.Offline != BackendRedis || c.Storage.RedisAddr != "", "storage.redis_addr is required when storage.offline is redis")
+	if c.Storage.History == BackendMongo {
+		check(c.Storage.MongoURI != "", "storage.mongo_uri is required when storage.history is mongo")
+		check(c.Storage.MongoDB != "", "storage.mongo_db is required when storage.history is mongo")
+		check(c.Storage.MongoCollection != "", "storage.mongo_collection is required when storage.history is mongo")
+	}
+
+	check(oneOf(c.Broker.Type, BackendMemory, BackendKafka, BackendRedis),
+		"broker.type must be %s, %s or %s, got %q", BackendMemory, BackendKafka, BackendRedis, c.Broker.Type)
+	if c.Broker.Type == BackendKafka {
+		check(len(c.Broker.KafkaBrokers) > 0, "broker.kafka_brokers is required when broker.type is kafka")
+		check(c.Broker.KafkaTopic != "", "broker.kafka_topic is required when broker.type is kafka")
+		// 消费组和节点 topic 都按节点 ID 命名，自动生成的 ID 每次启动都不同，重启后会丢失已提交的 offset
+		check(c.Cluster.NodeID != "", "cluster.node_id is required when broker.type is kafka")
+	}
+	if c.Broker.Type == BackendRedis {
+		check(c.Broker.RedisAddr != "", "broker.redis_addr is required when broker.type is redis")
+		check(c.Broker.RedisPrefix != "", "broker.redis_prefix is required when broker.type is redis")
+	}
+
+	check(oneOf(c.Presence.Type, BackendMemory, BackendRedis),
+		"presence.type must be %s or %s, got %q", BackendMemory, BackendRedis, c.Presence.Type)
+	check(c.Presence.TTL >= time.Second, "presence.ttl must be at least 1s")
+	if c.Presence.Type == BackendRedis {
+		check(c.Presence.RedisAddr != "", "presence.redis_addr is required when presence.type is redis")
+		check(c.Presence.RedisPrefix != "", "presence.redis_prefix is required when presence.type is redis")
+	}
+
+	return errors.Join(errs...)
+}
+
+func oneOf(v string, allowed ...string) bool {
+	return slices.Contains(allowed, v)
+}
@@ -0,0 +1,185 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix 环境变量前缀，配置项 server.addr 对应 WSSRV_SERVER_ADDR
+const EnvPrefix = "WSSRV_"
+
+// Load 在默认配置上叠加 YAML 文件和环境变量，path 为空时跳过文件
+// 文件中出现未知字段视为错误，避免拼写错误被静默忽略
+func Load(path string) (*Config, error) {
+	cfg := Default()
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read config: %w", err)
+		}
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("parse config %s: %w", path, err)
+		}
+	}
+	if err := cfg.ApplyEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Keys 所有可以通过环境变量和命令行覆盖的配置项，例如 server.addr
+func Keys() []string {
+	keys := make([]string, 0, len(Default().fields()))
+	for key := range Default().fields() {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// EnvName 配置项对应的环境变量名
+func EnvName(key string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// Set 按配置项名从字符串赋值，时长用 time.ParseDuration 的格式，列表用逗号分隔
+func (c *Config) Set(key, raw string) error {
+	v, ok := c.fields()[key]
+	if !ok {
+		return fmt.Errorf("unknown config key %q", key)
+	}
+	if err := v.Set(raw); err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	return nil
+}
+
+// ApplyEnv 用 WSSRV_* 环境变量覆盖配置，lookup 一般传 os.LookupEnv
+func (c *Config) ApplyEnv(lookup func(string) (string, bool)) error {
+	var errs []error
+	for key, v := range c.fields() {
+		name := EnvName(key)
+		raw, ok := lookup(name)
+		if !ok {
+			continue
+		}
+		if err := v.Set(raw); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// fields 配置项名到字段的映射，名字与 YAML 中的路径一致
+func (c *Config) fields() map[string]flag.Value {
+	return map[string]flag.Value{
+		"server.addr":               stringValue{&c.Server.Addr},
+		"server.read_timeout":       durationValue{&c.Server.ReadTimeout},
+		"server.write_timeout":      durationValue{&c.Server.WriteTimeout},
+		"server.shutdown_timeout":   durationValue{&c.Server.ShutdownTimeout},
+		"server.reconnect_delay":    durationValue{&c.Server.ReconnectDelay},
+		"server.tls_cert_file":      stringValue{&c.Server.TLSCertFile},
+		"server.tls_key_file":       stringValue{&c.Server.TLSKeyFile},
+		"server.tls_client_ca_file": stringValue{&c.Server.TLSClientCAFile},
+
+		"auth.secret":          stringValue{&c.Auth.Secret},
+		"auth.public_key_file": stringValue{&c.Auth.PublicKeyFile},
+		"auth.jwks_file":       stringValue{&c.Auth.JWKSFile},
+		"auth.issuer":          stringValue{&c.Auth.Issuer},
+		"auth.audience":        stringValue{&c.Auth.Audience},
+		"auth.leeway":          durationValue{&c.Auth.Leeway},
+
+		"connection.max_devices":   intValue{&c.Connection.MaxDevices},
+		"connection.device_policy": stringValue{&c.Connection.DevicePolicy},
+		"connection.queue_size":    intValue{&c.Connection.QueueSize},
+		"connection.send_policy":   stringValue{&c.Connection.SendPolicy},
+		"connection.write_timeout": durationValue{&c.Connection.WriteTimeout},
+		"connection.ping_interval": durationValue{&c.Connection.PingInterval},
+		"connection.pong_timeout":  durationValue{&c.Connection.PongTimeout},
+		"connection.event_timeout": durationValue{&c.Connection.EventTimeout},
+		"connection.slow_event":    durationValue{&c.Connection.SlowEvent},
+
+		"ack.timeout": durationValue{&c.Ack.Timeout},
+
+		"delivery.initial_backoff": durationValue{&c.Delivery.InitialBackoff},
+		"delivery.max_backoff":     durationValue{&c.Delivery.MaxBackoff},
+		"delivery.ttl":             durationValue{&c.Delivery.TTL},
+
+		"storage.offline":          stringValue{&c.Storage.Offline},
+		"storage.history":          stringValue{&c.Storage.History},
+		"storage.redis_addr":       stringValue{&c.Storage.RedisAddr},
+		"storage.mongo_uri":        stringValue{&c.Storage.MongoURI},
+		"storage.mongo_db":         stringValue{&c.Storage.MongoDB},
+		"storage.mongo_collection": stringValue{&c.Storage.MongoCollection},
+
+		"broker.type":               stringValue{&c.Broker.Type},
+		"broker.kafka_brokers":      listValue{&c.Broker.KafkaBrokers},
+		"broker.kafka_topic":        stringValue{&c.Broker.KafkaTopic},
+		"broker.kafka_group_prefix": stringValue{&c.Broker.KafkaGroupPrefix},
+		"broker.redis_addr":         stringValue{&c.Broker.RedisAddr},
+		"broker.redis_prefix":       stringValue{&c.Broker.RedisPrefix},
+
+		"cluster.node_id": stringValue{&c.Cluster.NodeID},
+
+		"presence.type":         stringValue{&c.Presence.Type},
+		"presence.redis_addr":   stringValue{&c.Presence.RedisAddr},
+		"presence.redis_prefix": stringValue{&c.Presence.RedisPrefix},
+		"presence.ttl":          durationValue{&c.Presence.TTL},
+	}
+}
+
+type stringValue struct{ p *string }
+
+func (v stringValue) String() string     { return *v.p }
+func (v stringValue) Set(s string) error { *v.p = s; return nil }
+
+type intValue struct{ p *int }
+
+func (v intValue) String() string { return strconv.Itoa(*v.p) }
+func (v intValue) Set(s string) error {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return err
+	}
+	*v.p = n
+	return nil
+}
+
+type durationValue struct{ p *time.Duration }
+
+func (v durationValue) String() string { return v.p.String() }
+func (v durationValue) Set(s string) error {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*v.p = d
+	return nil
+}
+
+// listValue 逗号分隔的列表
+type listValue struct{ p *[]string }
+
+func (v listValue) String() string { return strings.Join(*v.p, ",") }
+func (v listValue) Set(s string) error {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	*v.p = list
+	return nil
+}
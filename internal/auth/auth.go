@@ -4,35 +4,97 @@ import (
 	"errors"
 	"fmt"
 	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	// ErrTokenEmpty token 为空
+	ErrTokenEmpty = errors.New("token is empty")
+	// ErrInvalidToken token 签名、格式或有效期不合法
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrForbidden token 合法，但签发方或受众不被本服务接受
+	ErrForbidden = errors.New("token not accepted by this service")
 )
 
-// Auth 用于存储认证信息（如 JWT Secret）
+// Options 认证配置，Secret / PublicKeyFile / JWKSFile 三选一
+type Options struct {
+	Secret        string        // HS256 共享密钥
+	PublicKeyFile string        // RS256/ES256 公钥 PEM 文件
+	JWKSFile      string        // 本地 JWKS 文件，文件变化后自动重新加载，用于密钥轮换
+	Issuer        string        // 期望的 iss，为空则不校验
+	Audience      string        // 期望的 aud，为空则不校验
+	Leeway        time.Duration // exp/nbf 允许的时钟偏差
+}
+
+// Auth 负责校验 JWT 并提取身份信息
 type Auth struct {
-	// 可以存储密钥、过期时间等
-	SecretKey string
+	keys    keySource
+	methods []string
+	parser  *jwt.Parser
 }
 
 // NewAuth 创建一个新的认证实例
-func NewAuth() *Auth {
-	return &Auth{
-		SecretKey: "mysecretkey", // 示例密钥，实际使用时需要更加安全的做法
+func NewAuth(opts Options) (*Auth, error) {
+	var (
+		keys    keySource
+		methods []string
+	)
+	switch {
+	case opts.JWKSFile != "":
+		keys = newJWKSSource(opts.JWKSFile)
+		methods = []string{"RS256", "ES256"}
+	case opts.PublicKeyFile != "":
+		keys = newPEMSource(opts.PublicKeyFile)
+		methods = []string{"RS256", "ES256"}
+	case opts.Secret != "":
+		keys = staticSecret([]byte(opts.Secret))
+		methods = []string{"HS256"}
+	default:
+		return nil, errors.New("auth: one of secret, public key file or jwks file is required")
+	}
+
+	// 启动时先加载一次，尽早暴露配置错误
+	if err := keys.load(); err != nil {
+		return nil, fmt.Errorf("auth: load keys: %w", err)
 	}
+
+	parserOpts := []jwt.ParserOption{
+		jwt.WithValidMethods(methods),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(opts.Leeway),
+	}
+	if opts.Issuer != "" {
+		parserOpts = append(parserOpts, jwt.WithIssuer(opts.Issuer))
+	}
+	if opts.Audience != "" {
+		parserOpts = append(parserOpts, jwt.WithAudience(opts.Audience))
+	}
+
+	return &Auth{
+		keys:    keys,
+		methods: methods,
+		parser:  jwt.NewParser(parserOpts...),
+	}, nil
 }
 
-// ValidateToken 验证 token 是否有效
-// 这里只是示例，实际应该解密并验证 token
-func (a *Auth) ValidateToken(token string) (bool, error) {
-	// 在实际应用中，可以解密 token 或与数据库对比
+// ValidateToken 校验 token 的签名以及 exp/nbf/iss/aud，成功时返回其中的身份信息
+func (a *Auth) ValidateToken(token string) (*Principal, error) {
 	if token == "" {
-		return false, errors.New("token is empty")
+		return nil, ErrTokenEmpty
 	}
 
-	// 假设 token 有效期为 24 小时
-	expirationTime := time.Now().Add(24 * time.Hour)
-	if time.Now().After(expirationTime) {
-		return false, fmt.Errorf("token expired")
+	claims := &Claims{}
+	_, err := a.parser.ParseWithClaims(token, claims, a.keys.key)
+	if err != nil {
+		if errors.Is(err, jwt.ErrTokenInvalidIssuer) || errors.Is(err, jwt.ErrTokenInvalidAudience) {
+			return nil, fmt.Errorf("%w: %v", ErrForbidden, err)
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: missing sub", ErrInvalidToken)
 	}
 
-	// 假设 token 校验成功
-	return true, nil
+	return claims.principal(), nil
 }
@@ -0,0 +1,317 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testSecret = "test-secret"
+
+// testKeys 测试用的密钥对
+type testKeys struct {
+	rsa      *rsa.PrivateKey
+	rsaOther *rsa.PrivateKey
+	ec       *ecdsa.PrivateKey
+	ecOther  *ecdsa.PrivateKey
+}
+
+func newTestKeys(t *testing.T) *testKeys {
+	t.Helper()
+	k := &testKeys{}
+	var err error
+	for _, p := range []**rsa.PrivateKey{&k.rsa, &k.rsaOther} {
+		if *p, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, p := range []**ecdsa.PrivateKey{&k.ec, &k.ecOther} {
+		if *p, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return k
+}
+
+// writePEM 把公钥以 PEM 格式写入临时文件
+func writePEM(t *testing.T, pub crypto.PublicKey) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writeFile(t, "key.pem", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+// jwksData 把 kid -> 公钥编码为 JWKS
+func jwksData(t *testing.T, keys map[string]crypto.PublicKey) []byte {
+	t.Helper()
+	b64 := func(i *big.Int) string { return base64.RawURLEncoding.EncodeToString(i.Bytes()) }
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	for kid, pub := range keys {
+		switch pub := pub.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, jwk{Kty: "RSA", Kid: kid, Use: "sig", N: b64(pub.N), E: b64(big.NewInt(int64(pub.E)))})
+		case *ecdsa.PublicKey:
+			set.Keys = append(set.Keys, jwk{Kty: "EC", Kid: kid, Crv: "P-256", X: b64(pub.X), Y: b64(pub.Y)})
+		default:
+			t.Fatalf("unsupported key %T", pub)
+		}
+	}
+	data, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func writeFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// claims 有效期一小时、sub 为 alice 的声明，mod 可以修改其中的字段
+func claims(mod func(c *Claims)) *Claims {
+	now := time.Now()
+	c := &Claims{RegisteredClaims: jwt.RegisteredClaims{
+		Subject:   "alice",
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+	}}
+	if mod != nil {
+		mod(c)
+	}
+	return c
+}
+
+// sign 用 method 和 key 签发 token，kid 不为空时写入头部
+func sign(t *testing.T, method jwt.SigningMethod, key any, kid string, c jwt.Claims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, c)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	s, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestValidateToken(t *testing.T) {
+	keys := newTestKeys(t)
+	hsOpts := Options{Secret: testSecret}
+	rsaPEM := writePEM(t, &keys.rsa.PublicKey)
+	rsaOpts := Options{PublicKeyFile: rsaPEM}
+	ecOpts := Options{PublicKeyFile: writePEM(t, &keys.ec.PublicKey)}
+	jwksOpts := Options{JWKSFile: writeFile(t, "jwks.json", jwksData(t, map[string]crypto.PublicKey{
+		"rsa-1": &keys.rsa.PublicKey,
+		"ec-1":  &keys.ec.PublicKey,
+	}))}
+	singleJWKSOpts := Options{JWKSFile: writeFile(t, "jwks.json", jwksData(t, map[string]crypto.PublicKey{
+		"rsa-1": &keys.rsa.PublicKey,
+	}))}
+	rsaPEMBytes, err := os.ReadFile(rsaPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	withLeeway := func(o Options) Options { o.Leeway = time.Minute; return o }
+	withIssuer := func(o Options) Options { o.Issuer, o.Audience = "https://issuer.example", "wssrv"; return o }
+
+	hs := jwt.SigningMethodHS256
+	rs := jwt.SigningMethodRS256
+	es := jwt.SigningMethodES256
+	ago := func(d time.Duration) *jwt.NumericDate { return jwt.NewNumericDate(time.Now().Add(-d)) }
+	in := func(d time.Duration) *jwt.NumericDate { return jwt.NewNumericDate(time.Now().Add(d)) }
+
+	tests := []struct {
+		name    string
+		opts    Options
+		token   string
+		wantErr error
+	}{
+		{"hs256", hsOpts, sign(t, hs, []byte(testSecret), "", claims(nil)), nil},
+		{"hs256 wrong secret", hsOpts, sign(t, hs, []byte("other"), "", claims(nil)), ErrInvalidToken},
+		{"hs256 config rejects rs256", hsOpts, sign(t, rs, keys.rsa, "", claims(nil)), ErrInvalidToken},
+		{"rs256", rsaOpts, sign(t, rs, keys.rsa, "", claims(nil)), nil},
+		{"rs256 wrong key", rsaOpts, sign(t, rs, keys.rsaOther, "", claims(nil)), ErrInvalidToken},
+		{"es256", ecOpts, sign(t, es, keys.ec, "", claims(nil)), nil},
+		{"es256 wrong key", ecOpts, sign(t, es, keys.ecOther, "", claims(nil)), ErrInvalidToken},
+		// 用公钥 PEM 作为 HMAC 密钥伪造的 token
+		{"hs256 against pem key", rsaOpts, sign(t, hs, rsaPEMBytes, "", claims(nil)), ErrInvalidToken},
+		{"alg none", hsOpts, sign(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, "", claims(nil)), ErrInvalidToken},
+		{"alg none against pem key", rsaOpts, sign(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, "", claims(nil)), ErrInvalidToken},
+		{"malformed", hsOpts, "not.a.jwt", ErrInvalidToken},
+		{"empty", hsOpts, "", ErrTokenEmpty},
+
+		{"expired", hsOpts, sign(t, hs, []byte(testSecret), "", claims(func(c *Claims) { c.ExpiresAt = ago(30 * time.Second) })), ErrInvalidToken},
+		{"expired within leeway", withLeeway(hsOpts), sign(t, hs, []byte(testSecret), "", claims(func(c *Claims) { c.ExpiresAt = ago(30 * time.Second) })), nil},
+		{"expired beyond leeway", withLeeway(hsOpts), sign(t, hs, []byte(testSecret), "", claims(func(c *Claims) { c.ExpiresAt = ago(2 * time.Minute) })), ErrInvalidToken},
+		{"missing exp", hsOpts, sign(t, hs, []byte(testSecret), "", claims(func(c *Claims) { c.ExpiresAt = nil })), ErrInvalidToken},
+		{"not yet valid", hsOpts, sign(t, hs, []byte(testSecret), "", claims(func(c *Claims) { c.NotBefore = in(30 * time.Second) })), ErrInvalidToken},
+		{"not yet valid within leeway", withLeeway(hsOpts), sign(t, hs, []byte(testSecret), "", claims(func(c *Claims) { c.NotBefore = in(30 * time.Second) })), nil},
+
+		{"issuer and audience", withIssuer(hsOpts), sign(t, hs, []byte(testSecret), "", claims(func(c *Claims) {
+			c.Issuer, c.Audience = "https://issuer.example", jwt.ClaimStrings{"other", "wssrv"}
+		})), nil},
+		{"wrong issuer", withIssuer(hsOpts), sign(t, hs, []byte(testSecret), "", claims(func(c *Claims) {
+			c.Issuer, c.Audience = "https://evil.example", jwt.ClaimStrings{"wssrv"}
+		})), ErrForbidden},
+		// 缺少要求的声明属于 token 不合法，而不是签发方不被接受
+		{"missing issuer", withIssuer(hsOpts), sign(t, hs, []byte(testSecret), "", claims(func(c *Claims) {
+			c.Audience = jwt.ClaimStrings{"wssrv"}
+		})), ErrInvalidToken},
+		{"wrong audience", withIssuer(hsOpts), sign(t, hs, []byte(testSecret), "", claims(func(c *Claims) {
+			c.Issuer, c.Audience = "https://issuer.example", jwt.ClaimStrings{"other"}
+		})), ErrForbidden},
+		{"missing sub", hsOpts, sign(t, hs, []byte(testSecret), "", claims(func(c *Claims) { c.Subject = "" })), ErrInvalidToken},
+
+		{"jwks rsa kid", jwksOpts, sign(t, rs, keys.rsa, "rsa-1", claims(nil)), nil},
+		{"jwks ec kid", jwksOpts, sign(t, es, keys.ec, "ec-1", claims(nil)), nil},
+		{"jwks kid of another key", jwksOpts, sign(t, es, keys.ec, "rsa-1", claims(nil)), ErrInvalidToken},
+		{"jwks unknown kid", jwksOpts, sign(t, rs, keys.rsa, "rsa-2", claims(nil)), ErrInvalidToken},
+		{"jwks missing kid", jwksOpts, sign(t, rs, keys.rsa, "", claims(nil)), ErrInvalidToken},
+		// 只有一把密钥时忽略 kid
+		{"single jwks key ignores kid", singleJWKSOpts, sign(t, rs, keys.rsa, "rsa-2", claims(nil)), nil},
+		{"single jwks key wrong signer", singleJWKSOpts, sign(t, rs, keys.rsaOther, "rsa-2", claims(nil)), ErrInvalidToken},
+		{"pem ignores kid", rsaOpts, sign(t, rs, keys.rsa, "some-kid", claims(nil)), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := NewAuth(tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p, err := a.ValidateToken(tt.token)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("ValidateToken error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ValidateToken: %v", err)
+			}
+			if p.UserID != "alice" {
+				t.Fatalf("UserID = %q, want alice", p.UserID)
+			}
+		})
+	}
+}
+
+func TestValidateTokenPrincipal(t *testing.T) {
+	a, err := NewAuth(Options{Secret: testSecret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := time.Now().Add(time.Hour).Truncate(time.Second)
+	token := sign(t, jwt.SigningMethodHS256, []byte(testSecret), "", claims(func(c *Claims) {
+		c.Issuer = "https://issuer.example"
+		c.ExpiresAt = jwt.NewNumericDate(exp)
+		c.Name = "Alice"
+		c.DeviceID = "phone"
+		c.Roles = []string{"admin"}
+	}))
+
+	got, err := a.ValidateToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Principal{
+		UserID:    "alice",
+		Name:      "Alice",
+		DeviceID:  "phone",
+		Roles:     []string{"admin"},
+		Issuer:    "https://issuer.example",
+		ExpiresAt: exp,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("principal = %+v, want %+v", got, want)
+	}
+}
+
+func TestNewAuthOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+	}{
+		{"no key", Options{}},
+		{"missing pem file", Options{PublicKeyFile: filepath.Join(t.TempDir(), "missing.pem")}},
+		{"invalid pem", Options{PublicKeyFile: writeFile(t, "key.pem", []byte("not a key"))}},
+		{"empty jwks", Options{JWKSFile: writeFile(t, "jwks.json", []byte(`{"keys":[]}`))}},
+		{"jwks unsupported curve", Options{JWKSFile: writeFile(t, "jwks.json", []byte(`{"keys":[{"kty":"EC","crv":"P-384","x":"AQ","y":"AQ"}]}`))}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewAuth(tt.opts); err == nil {
+				t.Fatal("NewAuth succeeded, want error")
+			}
+		})
+	}
+}
+
+// TestJWKSReload 密钥轮换：JWKS 文件变化后使用新的密钥，旧密钥签发的 token 被拒绝
+func TestJWKSReload(t *testing.T) {
+	keys := newTestKeys(t)
+	path := writeFile(t, "jwks.json", jwksData(t, map[string]crypto.PublicKey{"k1": &keys.ec.PublicKey}))
+	a, err := NewAuth(Options{JWKSFile: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldToken := sign(t, jwt.SigningMethodES256, keys.ec, "k1", claims(nil))
+	newToken := sign(t, jwt.SigningMethodRS256, keys.rsa, "k2", claims(nil))
+
+	// 第一次校验时检查文件是否变化，修改时间推后以免与加载时相同
+	if err := os.WriteFile(path, jwksData(t, map[string]crypto.PublicKey{"k2": &keys.rsa.PublicKey}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := a.ValidateToken(newToken); err != nil {
+		t.Fatalf("token signed with the rotated key: %v", err)
+	}
+	if _, err := a.ValidateToken(oldToken); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("token signed with the removed key: error = %v, want %v", err, ErrInvalidToken)
+	}
+}
+
+// TestJWKSReloadKeepsKeysOnError 文件被写坏时继续使用之前加载的密钥
+func TestJWKSReloadKeepsKeysOnError(t *testing.T) {
+	keys := newTestKeys(t)
+	path := writeFile(t, "jwks.json", jwksData(t, map[string]crypto.PublicKey{"k1": &keys.ec.PublicKey}))
+	a, err := NewAuth(Options{JWKSFile: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := a.ValidateToken(sign(t, jwt.SigningMethodES256, keys.ec, "k1", claims(nil))); err != nil {
+		t.Fatalf("ValidateToken after a broken reload: %v", err)
+	}
+}
@@ -0,0 +1,160 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+
+	"github.com/focusandinsist/go-ws-srv/internal/reload"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// keySource 根据 token 头部选出用于验签的密钥
+type keySource interface {
+	load() error
+	key(token *jwt.Token) (any, error)
+}
+
+// staticSecret HS256 共享密钥
+type staticSecret []byte
+
+func (s staticSecret) load() error { return nil }
+
+func (s staticSecret) key(*jwt.Token) (any, error) { return []byte(s), nil }
+
+// fileKeys 从文件加载的一组公钥，按 kid 索引；文件变化后整体替换密钥集合，加载后不再修改
+type fileKeys struct {
+	keys *reload.Reloader[map[string]any]
+}
+
+func newPEMSource(path string) *fileKeys {
+	return newFileKeys(path, parsePEM)
+}
+
+func newJWKSSource(path string) *fileKeys {
+	return newFileKeys(path, parseJWKS)
+}
+
+func newFileKeys(path string, parse func([]byte) (map[string]any, error)) *fileKeys {
+	return &fileKeys{keys: reload.New(func() (map[string]any, error) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		keys, err := parse(data)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Loaded %d key(s) from %s", len(keys), path)
+		return keys, nil
+	}, path)}
+}
+
+func (f *fileKeys) load() error { return f.keys.Load() }
+
+func (f *fileKeys) key(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	keys := f.keys.Get()
+	if k, ok := keys[kid]; ok {
+		return k, nil
+	}
+	// 只有一把密钥时忽略 kid：PEM 文件中的公钥没有 kid，而多数签发方仍会在头部带上 kid
+	if len(keys) == 1 {
+		for _, k := range keys {
+			return k, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown key id %q", kid)
+}
+
+func parsePEM(data []byte) (map[string]any, error) {
+	if k, err := jwt.ParseRSAPublicKeyFromPEM(data); err == nil {
+		return map[string]any{"": k}, nil
+	}
+	if k, err := jwt.ParseECPublicKeyFromPEM(data); err == nil {
+		return map[string]any{"": k}, nil
+	}
+	return nil, errors.New("no RSA or EC public key found in PEM")
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func parseJWKS(data []byte) (map[string]any, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]any, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pub, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("jwk %q: %w", k.Kid, err)
+		}
+		keys[k.Kid] = pub
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("jwks contains no signing keys")
+	}
+	return keys, nil
+}
+
+func (k *jwk) publicKey() (any, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
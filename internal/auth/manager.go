@@ -9,6 +9,7 @@ import (
 
 // AuthManager 用于管理 token 和 session 状态
 type AuthManager struct {
+	auth         *Auth               // 负责校验 token
 	activeTokens map[string]*Session // 存储用户的活跃 Token
 	mu           sync.Mutex          // 用于保护并发操作
 }
@@ -22,12 +23,18 @@ type Session struct {
 }
 
 // NewAuthManager 创建一个新的 AuthManager
-func NewAuthManager() *AuthManager {
+func NewAuthManager(a *Auth) *AuthManager {
 	return &AuthManager{
+		auth:         a,
 		activeTokens: make(map[string]*Session),
 	}
 }
 
+// Authenticate 校验握手时携带的 token，返回对应的身份
+func (am *AuthManager) Authenticate(token string) (*Principal, error) {
+	return am.auth.ValidateToken(token)
+}
+
 // CreateSession 创建一个新的认证 Session
 func (am *AuthManager) CreateSession(userID, token string) (*Session, error) {
 	am.mu.Lock()
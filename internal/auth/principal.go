@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"slices"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Claims token 中携带的声明
+type Claims struct {
+	jwt.RegisteredClaims
+	Name     string   `json:"name,omitempty"`
+	DeviceID string   `json:"device_id,omitempty"`
+	Roles    []string `json:"roles,omitempty"`
+}
+
+// Principal 通过认证的身份，供服务内其他模块使用
+type Principal struct {
+	UserID    string    // 用户 ID，来自 sub
+	Name      string    // 展示名
+	DeviceID  string    // 设备 ID（可选）
+	Roles     []string  // 角色
+	Issuer    string    // 签发方
+	ExpiresAt time.Time // 过期时间
+}
+
+// HasRole 判断是否拥有指定角色
+func (p *Principal) HasRole(role string) bool {
+	if p == nil {
+		return false
+	}
+	return slices.Contains(p.Roles, role)
+}
+
+func (c *Claims) principal() *Principal {
+	p := &Principal{
+		UserID:   c.Subject,
+		Name:     c.Name,
+		DeviceID: c.DeviceID,
+		Roles:    c.Roles,
+		Issuer:   c.Issuer,
+	}
+	if c.ExpiresAt != nil {
+		p.ExpiresAt = c.ExpiresAt.Time
+	}
+	return p
+}
@@ -0,0 +1,22 @@
+// 消息分发层
+// 职责：节点之间的消息同步。每个节点发布本地收到的消息，并消费其他节点发布的消息投递给本地连接。
+// Kafka 实现用于集群部署，Redis Pub/Sub 实现是更轻量的集群方案，内存实现只在进程内分发，用于本地开发和单节点部署。
+package broker
+
+// Broker 消息分发接口
+type Broker interface {
+	// SendMessage 发布一条消息，不阻塞；key 相同的消息保持发布顺序
+	SendMessage(key, message string)
+	// SendToNode 发布一条只需要 nodeID 节点处理的消息，不阻塞；key 的含义与 SendMessage 相同
+	SendToNode(nodeID, key, message string)
+	// ConsumeMessages 持续消费消息并调用 handler，阻塞到 Close
+	ConsumeMessages(handler func(string)) error
+	// Close 发出所有已缓冲的消息后释放资源
+	Close() error
+}
+
+var (
+	_ Broker = (*KafkaBroker)(nil)
+	_ Broker = (*MemoryBroker)(nil)
+	_ Broker = (*RedisBroker)(nil)
+)
@@ -1,83 +1,200 @@
 package broker
 
 import (
+	"context"
+	"errors"
 	"log"
+	"sync"
+	"time"
 
 	"github.com/IBM/sarama"
+
+	"github.com/focusandinsist/go-ws-srv/internal/metrics"
 )
 
+// consumeRetryInterval 消费出错后重新加入消费组前的等待时间
+const consumeRetryInterval = 2 * time.Second
+
+// KafkaBroker 基于 Kafka 的消息分发
+// 消息以会话 ID 为 key 发布，同一会话的消息进入同一分区，保证会话内有序。
+// 每个节点使用独立的消费组（groupID 通常为前缀加节点 ID），因此每个节点都能收到全部消息；
+// 消息投递给本地连接后再标记 offset，节点以相同 ID 重启时从上次提交的位置继续消费。
+// 发给指定节点的消息写入该节点自己的 topic（topic + "." + 节点 ID），只有该节点消费；
+// 集群关闭了自动创建 topic 时，需要为每个节点预先创建这个 topic。
 type KafkaBroker struct {
 	producer sarama.AsyncProducer
-	consumer sarama.Consumer
+	group    sarama.ConsumerGroup
 	topic    string
+	nodeID   string // 当前节点，除 topic 外还消费 nodeTopic(nodeID)
+
+	ctx    context.Context // Close 时取消，结束 ConsumeMessages
+	cancel context.CancelFunc
+
+	mu     sync.Mutex // 保护 closed，避免 Close 之后写入已关闭的 producer
+	closed bool
 }
 
-func NewKafkaBroker(brokers []string, topic string) (*KafkaBroker, error) {
+// NewKafkaBroker 创建 Kafka 消息分发，nodeID 为当前节点，用于消费发给本节点的消息
+func NewKafkaBroker(brokers []string, topic, groupID, nodeID string) (*KafkaBroker, error) {
 	config := sarama.NewConfig()
 	config.Producer.Return.Successes = true
 	config.Producer.Return.Errors = true
+	// 相同 key（会话）的消息进入同一分区，保证会话内有序
+	config.Producer.Partitioner = sarama.NewHashPartitioner
+	// 新的消费组从最新位置开始，不回放历史消息；历史消息由 sync 补齐
+	config.Consumer.Offsets.Initial = sarama.OffsetNewest
+	config.Consumer.Return.Errors = true
 
 	producer, err := sarama.NewAsyncProducer(brokers, config)
 	if err != nil {
 		return nil, err
 	}
 
-	consumer, err := sarama.NewConsumer(brokers, nil)
+	group, err := sarama.NewConsumerGroup(brokers, groupID, config)
 	if err != nil {
+		producer.Close()
 		return nil, err
 	}
+	return newKafkaBroker(producer, group, topic, nodeID), nil
+}
 
-	// 处理成功和错误的返回
+// newKafkaBroker 用已经创建好的 producer 和消费组构造 broker，可以传入 sarama/mocks 的实现
+func newKafkaBroker(producer sarama.AsyncProducer, group sarama.ConsumerGroup, topic, nodeID string) *KafkaBroker {
+	// 处理成功和错误的返回，producer 关闭后两个 channel 都会被关闭
 	go func() {
-		for {
+		successes, errs := producer.Successes(), producer.Errors()
+		for successes != nil || errs != nil {
 			select {
-			case msg := <-producer.Successes():
+			case msg, ok := <-successes:
+				if !ok {
+					successes = nil
+					continue
+				}
 				log.Printf("Message sent to topic %s partition %d at offset %d\n", msg.Topic, msg.Partition, msg.Offset)
-			case err := <-producer.Errors():
+			case err, ok := <-errs:
+				if !ok {
+					errs = nil
+					continue
+				}
 				log.Printf("Failed to send message: %v\n", err)
 			}
 		}
 	}()
 
+	// 消费组的错误只记录，不中断消费
+	go func() {
+		for err := range group.Errors() {
+			log.Printf("Kafka consumer group error: %v", err)
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
 	return &KafkaBroker{
 		producer: producer,
-		consumer: consumer,
+		group:    group,
 		topic:    topic,
-	}, nil
+		nodeID:   nodeID,
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+func (kb *KafkaBroker) SendMessage(key, message string) {
+	kb.produce(kb.topic, key, message)
+}
+
+// SendToNode 发到节点自己的 topic，同样以会话 ID 为 key，保证发给同一节点的会话内有序
+func (kb *KafkaBroker) SendToNode(nodeID, key, message string) {
+	kb.produce(kb.nodeTopic(nodeID), key, message)
+}
+
+func (kb *KafkaBroker) nodeTopic(nodeID string) string {
+	return kb.topic + "." + nodeID
 }
 
-func (kb *KafkaBroker) SendMessage(message string) {
+func (kb *KafkaBroker) produce(topic, key, message string) {
 	msg := &sarama.ProducerMessage{
-		Topic: kb.topic,
+		Topic: topic,
 		Value: sarama.StringEncoder(message),
 	}
-	kb.producer.Input() <- msg
-}
+	if key != "" {
+		msg.Key = sarama.StringEncoder(key)
+	}
 
-func (kb *KafkaBroker) ConsumeMessages(handler func(string)) {
-	partitionConsumer, err := kb.consumer.ConsumePartition(kb.topic, 0, sarama.OffsetNewest)
-	if err != nil {
-		log.Fatal(err)
+	kb.mu.Lock()
+	defer kb.mu.Unlock()
+	if kb.closed {
+		return
 	}
+	// producer 的输入缓冲已满（Kafka 不可用或写入跟不上）时丢弃，不阻塞调用方
+	select {
+	case kb.producer.Input() <- msg:
+	default:
+		metrics.BrokerDropped.Add(1)
+		log.Printf("Kafka producer input is full, dropping message to topic %s", topic)
+	}
+}
 
-	defer partitionConsumer.Close()
+// Close 等待 producer 发出缓冲中的消息后关闭 producer，再退出消费组（提交已标记的 offset）
+func (kb *KafkaBroker) Close() error {
+	kb.cancel()
+	kb.mu.Lock()
+	kb.closed = true
+	kb.mu.Unlock()
+	return errors.Join(kb.producer.Close(), kb.group.Close())
+}
 
-	for message := range partitionConsumer.Messages() {
-		handler(string(message.Value))
+// ConsumeMessages 以消费组方式消费共用 topic 和本节点 topic 的所有分区，阻塞到 Close
+// 每个分区在单独的协程中按顺序调用 handler，不同分区之间并发；重平衡后自动重新加入消费组
+func (kb *KafkaBroker) ConsumeMessages(handler func(string)) error {
+	h := &groupHandler{handle: handler}
+	topics := []string{kb.topic, kb.nodeTopic(kb.nodeID)}
+	for {
+		// Consume 在一次会话结束（例如重平衡）时返回，需要循环调用
+		err := kb.group.Consume(kb.ctx, topics, h)
+		if errors.Is(err, sarama.ErrClosedConsumerGroup) || kb.ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			log.Printf("Kafka consume error, retrying in %s: %v", consumeRetryInterval, err)
+			select {
+			case <-kb.ctx.Done():
+				return nil
+			case <-time.After(consumeRetryInterval):
+			}
+		}
 	}
 }
 
-func (kb *KafkaBroker) ConsumeUserMessages(userID string, handler func(string)) {
-	partitionConsumer, err := kb.consumer.ConsumePartition(kb.topic, 0, sarama.OffsetNewest)
-	if err != nil {
-		log.Fatal(err)
-	}
+// groupHandler 实现 sarama.ConsumerGroupHandler
+type groupHandler struct {
+	handle func(string)
+}
 
-	defer partitionConsumer.Close()
+// Setup 新的会话开始（包括重平衡之后），记录分配到的分区
+func (h *groupHandler) Setup(sess sarama.ConsumerGroupSession) error {
+	log.Printf("Kafka consumer group session started, member %s, claims %v", sess.MemberID(), sess.Claims())
+	return nil
+}
+
+// Cleanup 会话结束，已标记的 offset 会在这之后提交
+func (h *groupHandler) Cleanup(sess sarama.ConsumerGroupSession) error {
+	log.Printf("Kafka consumer group session ended, member %s", sess.MemberID())
+	return nil
+}
 
-	for message := range partitionConsumer.Messages() {
-		if string(message.Key) == userID {
-			handler(string(message.Value))
+// ConsumeClaim 按顺序处理一个分区的消息，投递完成后才标记 offset
+func (h *groupHandler) ConsumeClaim(sess sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	for {
+		select {
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			h.handle(string(msg.Value))
+			sess.MarkMessage(msg, "")
+		case <-sess.Context().Done():
+			return nil
 		}
 	}
 }
@@ -0,0 +1,274 @@
+package broker
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+
+	"github.com/focusandinsist/go-ws-srv/internal/metrics"
+)
+
+const (
+	testTopic     = "ws-messages"
+	testGroup     = "ws-node-1"
+	testNode      = "node-1"
+	testNodeTopic = testTopic + "." + testNode
+)
+
+// newTestConfig 测试用的配置，与 NewKafkaBroker 一致，缩短自动提交间隔
+func newTestConfig() *sarama.Config {
+	config := sarama.NewConfig()
+	config.Version = sarama.V2_0_0_0
+	config.Producer.Return.Successes = true
+	config.Producer.Return.Errors = true
+	config.Producer.Partitioner = sarama.NewHashPartitioner
+	config.Consumer.Offsets.Initial = sarama.OffsetNewest
+	config.Consumer.Offsets.AutoCommit.Interval = 10 * time.Millisecond
+	config.Consumer.Return.Errors = true
+	return config
+}
+
+// newMockGroup 启动一个 mock broker 作为消费组协调者，共用 topic 和 testNode 的 topic 都存在，
+// 消费组只分配到 topic 的分区 0，上面有 values 对应的消息，offset 从 0 开始
+func newMockGroup(t *testing.T, config *sarama.Config, topic string, values ...string) (*sarama.MockBroker, sarama.ConsumerGroup) {
+	t.Helper()
+	mb := sarama.NewMockBroker(t, 0)
+	t.Cleanup(mb.Close)
+
+	fetches := make([]any, 0, len(values)+1)
+	for i, v := range values {
+		fetches = append(fetches, sarama.NewMockFetchResponse(t, 1).
+			SetMessage(topic, 0, int64(i), sarama.StringEncoder(v)))
+	}
+	// 消息取完后返回空结果
+	fetches = append(fetches, sarama.NewMockFetchResponse(t, 1))
+
+	mb.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(mb.Addr(), mb.BrokerID()).
+			SetLeader(testTopic, 0, mb.BrokerID()).
+			SetLeader(testNodeTopic, 0, mb.BrokerID()),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			SetOffset(topic, 0, sarama.OffsetOldest, 0).
+			SetOffset(topic, 0, sarama.OffsetNewest, int64(len(values))),
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).
+			SetCoordinator(sarama.CoordinatorGroup, testGroup, mb),
+		"JoinGroupRequest": sarama.NewMockJoinGroupResponse(t).
+			SetGroupProtocol(sarama.RangeBalanceStrategyName),
+		"SyncGroupRequest": sarama.NewMockSyncGroupResponse(t).
+			SetMemberAssignment(&sarama.ConsumerGroupMemberAssignment{
+				Topics: map[string][]int32{topic: {0}},
+			}),
+		"HeartbeatRequest": sarama.NewMockHeartbeatResponse(t),
+		// 已提交的 offset 为 0，从第一条消息开始消费
+		"OffsetFetchRequest": sarama.NewMockOffsetFetchResponse(t).
+			SetOffset(testGroup, topic, 0, 0, "", sarama.ErrNoError).
+			SetError(sarama.ErrNoError),
+		"FetchRequest":        sarama.NewMockSequence(fetches...),
+		"OffsetCommitRequest": sarama.NewMockOffsetCommitResponse(t),
+		"LeaveGroupRequest":   sarama.NewMockLeaveGroupResponse(t),
+	})
+
+	group, err := sarama.NewConsumerGroup([]string{mb.Addr()}, testGroup, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mb, group
+}
+
+// committedOffset 返回 mock broker 收到的 topic 分区 0 的最大已提交 offset，没有提交时返回 -1
+func committedOffset(mb *sarama.MockBroker, topic string) int64 {
+	committed := int64(-1)
+	for _, rr := range mb.History() {
+		req, ok := rr.Request.(*sarama.OffsetCommitRequest)
+		if !ok {
+			continue
+		}
+		if offset, _, err := req.Offset(topic, 0); err == nil {
+			committed = max(committed, offset)
+		}
+	}
+	return committed
+}
+
+func TestKafkaBrokerKeyedProduce(t *testing.T) {
+	config := newTestConfig()
+	producer := mocks.NewAsyncProducer(t, config)
+	_, group := newMockGroup(t, config, testTopic)
+	kb := newKafkaBroker(producer, group, testTopic, testNode)
+
+	expectKey := func(topic, key, value string) {
+		producer.ExpectInputWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+			if msg.Topic != topic {
+				return fmt.Errorf("topic = %q, want %q", msg.Topic, topic)
+			}
+			var got string
+			if msg.Key != nil {
+				b, _ := msg.Key.Encode()
+				got = string(b)
+			}
+			if got != key {
+				return fmt.Errorf("key = %q, want %q", got, key)
+			}
+			b, _ := msg.Value.Encode()
+			if string(b) != value {
+				return fmt.Errorf("value = %q, want %q", b, value)
+			}
+			return nil
+		})
+	}
+
+	expectKey(testTopic, "dm:alice:bob", "hello")
+	kb.SendMessage("dm:alice:bob", "hello")
+
+	// 发给指定节点的消息写入该节点的 topic，同样以会话 ID 为 key，而不是节点 ID
+	expectKey(testTopic+".node-2", "dm:alice:bob", "direct")
+	kb.SendToNode("node-2", "dm:alice:bob", "direct")
+
+	expectKey(testTopic, "", "no key")
+	kb.SendMessage("", "no key")
+
+	if err := kb.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// stalledProducer 从不读取输入的 producer，模拟 Kafka 不可用、输入缓冲已满
+type stalledProducer struct {
+	sarama.AsyncProducer
+	input chan *sarama.ProducerMessage
+}
+
+func (p *stalledProducer) Input() chan<- *sarama.ProducerMessage     { return p.input }
+func (p *stalledProducer) Successes() <-chan *sarama.ProducerMessage { return nil }
+func (p *stalledProducer) Errors() <-chan *sarama.ProducerError      { return nil }
+func (p *stalledProducer) Close() error                              { return nil }
+
+func TestKafkaBrokerDropsWhenInputFull(t *testing.T) {
+	config := newTestConfig()
+	_, group := newMockGroup(t, config, testTopic)
+	producer := &stalledProducer{input: make(chan *sarama.ProducerMessage, 1)}
+	kb := newKafkaBroker(producer, group, testTopic, testNode)
+
+	before := metrics.BrokerDropped.Value()
+	sent := make(chan struct{})
+	go func() {
+		kb.SendMessage("dm:alice:bob", "first")
+		kb.SendMessage("dm:alice:bob", "second")
+		kb.SendToNode("node-2", "dm:alice:bob", "third")
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("send blocked on a full producer input")
+	}
+	if got := metrics.BrokerDropped.Value() - before; got != 2 {
+		t.Fatalf("dropped = %d, want 2", got)
+	}
+
+	if err := kb.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Close 之后的消息直接丢弃，不写入已关闭的 producer
+	kb.SendMessage("dm:alice:bob", "after close")
+	if len(producer.input) != 1 {
+		t.Fatalf("queued = %d, want 1", len(producer.input))
+	}
+}
+
+func TestKafkaBrokerConsumeAndMarkAfterHandler(t *testing.T) {
+	config := newTestConfig()
+	producer := mocks.NewAsyncProducer(t, config)
+	mb, group := newMockGroup(t, config, testTopic, "first", "second")
+	kb := newKafkaBroker(producer, group, testTopic, testNode)
+
+	received := make(chan string)
+	release := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- kb.ConsumeMessages(func(message string) {
+			received <- message
+			<-release
+		})
+	}()
+
+	for i, want := range []string{"first", "second"} {
+		select {
+		case got := <-received:
+			if got != want {
+				t.Fatalf("message %d = %q, want %q", i, got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for message %d", i)
+		}
+
+		// handler 返回前，即使经过多个自动提交周期，这条消息也不能被提交
+		time.Sleep(10 * config.Consumer.Offsets.AutoCommit.Interval)
+		if offset := committedOffset(mb, testTopic); offset > int64(i) {
+			t.Fatalf("offset %d committed before handler returned for message %d", offset, i)
+		}
+		release <- struct{}{}
+	}
+
+	// 两条消息都处理完后，提交的 offset 为下一条要消费的位置
+	deadline := time.Now().Add(5 * time.Second)
+	for committedOffset(mb, testTopic) != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("committed offset = %d, want 2", committedOffset(mb, testTopic))
+		}
+		time.Sleep(config.Consumer.Offsets.AutoCommit.Interval)
+	}
+
+	if err := kb.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ConsumeMessages did not return after Close")
+	}
+}
+
+// TestKafkaBrokerConsumeNodeTopic 消费组同时订阅本节点的 topic，发给本节点的消息也会被处理
+func TestKafkaBrokerConsumeNodeTopic(t *testing.T) {
+	config := newTestConfig()
+	producer := mocks.NewAsyncProducer(t, config)
+	mb, group := newMockGroup(t, config, testNodeTopic, "to node-1")
+	kb := newKafkaBroker(producer, group, testTopic, testNode)
+
+	received := make(chan string, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- kb.ConsumeMessages(func(message string) { received <- message })
+	}()
+
+	select {
+	case got := <-received:
+		if got != "to node-1" {
+			t.Fatalf("received %q, want %q", got, "to node-1")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the node message")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for committedOffset(mb, testNodeTopic) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("committed offset = %d, want 1", committedOffset(mb, testNodeTopic))
+		}
+		time.Sleep(config.Consumer.Offsets.AutoCommit.Interval)
+	}
+
+	if err := kb.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,72 @@
+package broker
+
+import (
+	"log"
+	"sync"
+)
+
+// memoryBufferSize 每个订阅者的缓冲区大小，消费跟不上时丢弃新消息
+const memoryBufferSize = 1024
+
+// MemoryBroker 进程内的消息分发，所有订阅者都会收到每条消息
+type MemoryBroker struct {
+	subscribers []chan string
+	closed      bool
+	mu          sync.RWMutex
+}
+
+// NewMemoryBroker 创建内存消息分发
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{}
+}
+
+func (mb *MemoryBroker) SendMessage(_, message string) {
+	mb.mu.RLock()
+	defer mb.mu.RUnlock()
+	if mb.closed {
+		return
+	}
+	for _, ch := range mb.subscribers {
+		select {
+		case ch <- message:
+		default:
+			log.Println("Memory broker subscriber is full, dropping message")
+		}
+	}
+}
+
+// SendToNode 进程内只有一个节点，等同于 SendMessage
+func (mb *MemoryBroker) SendToNode(_, key, message string) {
+	mb.SendMessage(key, message)
+}
+
+func (mb *MemoryBroker) ConsumeMessages(handler func(string)) error {
+	ch := make(chan string, memoryBufferSize)
+	mb.mu.Lock()
+	if mb.closed {
+		mb.mu.Unlock()
+		return nil
+	}
+	mb.subscribers = append(mb.subscribers, ch)
+	mb.mu.Unlock()
+
+	for message := range ch {
+		handler(message)
+	}
+	return nil
+}
+
+// Close 关闭所有订阅者，ConsumeMessages 处理完缓冲中的消息后返回
+func (mb *MemoryBroker) Close() error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	if mb.closed {
+		return nil
+	}
+	mb.closed = true
+	for _, ch := range mb.subscribers {
+		close(ch)
+	}
+	mb.subscribers = nil
+	return nil
+}
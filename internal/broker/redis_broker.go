@@ -0,0 +1,189 @@
+package broker
+
+import (
+	"context"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/focusandinsist/go-ws-srv/internal/metrics"
+	"github.com/focusandinsist/go-ws-srv/internal/storage"
+)
+
+// redisPublishBuffer 等待发布的消息数上限，超过时丢弃新消息
+const redisPublishBuffer = 4096
+
+// maxSubscribeRetryInterval 订阅失败后重试间隔的上限，间隔从 consumeRetryInterval 开始每次翻倍
+const maxSubscribeRetryInterval = 30 * time.Second
+
+// RedisBroker 基于 Redis Pub/Sub 的消息分发，类似 socket.io-redis-adapter
+// 每条消息发布到 prefix+key 频道（key 为会话 ID 或命名空间，即按命名空间/房间/单聊分频道），
+// 所有节点都订阅这些频道，任何节点都可以向任意房间发送消息；
+// 发给指定节点的消息走 prefix+"node:"+nodeID 频道，只有该节点订阅。
+// Pub/Sub 不持久化，节点离线期间的消息不会补发，由离线队列和 sync 补齐。
+type RedisBroker struct {
+	store  *storage.RedisStorage
+	prefix string
+	nodeID string
+
+	queue chan redisMessage // 由单个协程按顺序发布，保证同一 key 的消息有序
+	done  chan struct{}     // 发布协程退出后关闭
+	stop  chan struct{}     // Close 时关闭，结束订阅的重试
+
+	retryInterval time.Duration // 第一次重新订阅前的等待时间
+
+	mu     sync.Mutex
+	subs   []*redisSubscription
+	closed bool
+}
+
+type redisMessage struct {
+	channel string
+	payload string
+}
+
+type redisSubscription struct {
+	cancel context.CancelFunc
+	close  func() error
+}
+
+// NewRedisBroker 创建 Redis Pub/Sub 消息分发，store 由 broker 持有，Close 时一起关闭
+// nodeID 为当前节点，用于订阅发给本节点的消息
+func NewRedisBroker(store *storage.RedisStorage, prefix, nodeID string) (*RedisBroker, error) {
+	if err := store.Client().Ping(context.Background()).Err(); err != nil {
+		store.Close()
+		return nil, err
+	}
+	rb := &RedisBroker{
+		store:  store,
+		prefix: prefix,
+		nodeID: nodeID,
+		queue:  make(chan redisMessage, redisPublishBuffer),
+		done:   make(chan struct{}),
+		stop:   make(chan struct{}),
+
+		retryInterval: consumeRetryInterval,
+	}
+	go rb.publishLoop()
+	return rb, nil
+}
+
+func (rb *RedisBroker) SendMessage(key, message string) {
+	rb.enqueue(rb.prefix+key, message)
+}
+
+// SendToNode 发到节点自己的频道，所有消息由同一个协程按顺序发布，不需要 key
+func (rb *RedisBroker) SendToNode(nodeID, _, message string) {
+	rb.enqueue(rb.nodeChannel(nodeID), message)
+}
+
+func (rb *RedisBroker) nodeChannel(nodeID string) string {
+	return rb.prefix + "node:" + nodeID
+}
+
+func (rb *RedisBroker) enqueue(channel, message string) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	if rb.closed {
+		return
+	}
+	select {
+	case rb.queue <- redisMessage{channel: channel, payload: message}:
+	default:
+		metrics.BrokerDropped.Add(1)
+		log.Println("Redis broker publish queue is full, dropping message")
+	}
+}
+
+func (rb *RedisBroker) publishLoop() {
+	defer close(rb.done)
+	for m := range rb.queue {
+		if err := rb.store.Client().Publish(context.Background(), m.channel, m.payload).Err(); err != nil {
+			log.Printf("Failed to publish to %s: %v", m.channel, err)
+		}
+	}
+}
+
+// ConsumeMessages 订阅命名空间广播、房间、单聊频道和本节点的频道，阻塞到 Close
+// 订阅失败（例如 Redis 暂时不可用）时按退避间隔重试，直到 Close；订阅成功后断线由 go-redis 自动重连
+func (rb *RedisBroker) ConsumeMessages(handler func(string)) error {
+	backoff := rb.retryInterval
+	for {
+		err := rb.consume(handler)
+		if err == nil {
+			return nil
+		}
+		log.Printf("Redis subscribe failed, retrying in %s: %v", backoff, err)
+		select {
+		case <-rb.stop:
+			return nil
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxSubscribeRetryInterval)
+	}
+}
+
+// consume 订阅一次并处理消息直到 Close，订阅失败时返回错误
+// 命名空间以 / 开头，会话 ID 以 room: 或 dm: 开头，见 protocol.ConversationID
+func (rb *RedisBroker) consume(handler func(string)) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ps := rb.store.Client().PSubscribe(ctx, rb.prefix+"/*", rb.prefix+"room:*", rb.prefix+"dm:*")
+	if err := ps.Subscribe(ctx, rb.nodeChannel(rb.nodeID)); err != nil {
+		ps.Close()
+		return err
+	}
+
+	sub := &redisSubscription{cancel: cancel, close: ps.Close}
+	rb.mu.Lock()
+	if rb.closed {
+		rb.mu.Unlock()
+		ps.Close()
+		return nil
+	}
+	rb.subs = append(rb.subs, sub)
+	rb.mu.Unlock()
+
+	// 等待订阅确认，尽早暴露连接错误
+	if _, err := ps.Receive(ctx); err != nil {
+		rb.removeSub(sub)
+		ps.Close()
+		return err
+	}
+
+	// Close 时 channel 会被关闭
+	for msg := range ps.Channel() {
+		handler(msg.Payload)
+	}
+	return nil
+}
+
+// removeSub 删除订阅失败的 PubSub，Close 时不再重复关闭
+func (rb *RedisBroker) removeSub(sub *redisSubscription) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	rb.subs = slices.DeleteFunc(rb.subs, func(s *redisSubscription) bool { return s == sub })
+}
+
+// Close 发布完队列中的消息后取消订阅并关闭 Redis 连接
+func (rb *RedisBroker) Close() error {
+	rb.mu.Lock()
+	if rb.closed {
+		rb.mu.Unlock()
+		return nil
+	}
+	rb.closed = true
+	subs := rb.subs
+	rb.subs = nil
+	close(rb.queue)
+	close(rb.stop)
+	rb.mu.Unlock()
+
+	<-rb.done
+	for _, sub := range subs {
+		sub.close()
+		sub.cancel()
+	}
+	return rb.store.Close()
+}
@@ -0,0 +1,202 @@
+package broker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+
+	"github.com/focusandinsist/go-ws-srv/internal/storage"
+)
+
+const testPrefix = "ws:"
+
+// redisNode 一个节点的 broker 和它收到的消息
+type redisNode struct {
+	broker   *RedisBroker
+	received chan string
+	done     chan error
+}
+
+// startRedisNode 创建 broker 并开始消费
+func startRedisNode(t *testing.T, addr, nodeID string) *redisNode {
+	t.Helper()
+	rb, err := NewRedisBroker(storage.NewRedisStorage(addr), testPrefix, nodeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := &redisNode{broker: rb, received: make(chan string, 16), done: make(chan error, 1)}
+	go func() {
+		n.done <- rb.ConsumeMessages(func(message string) { n.received <- message })
+	}()
+	t.Cleanup(func() {
+		rb.Close()
+		select {
+		case err := <-n.done:
+			if err != nil {
+				t.Error(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Error("ConsumeMessages did not return after Close")
+		}
+	})
+	return n
+}
+
+// expect 等待下一条消息并检查内容
+func (n *redisNode) expect(t *testing.T, want string) {
+	t.Helper()
+	select {
+	case got := <-n.received:
+		if got != want {
+			t.Fatalf("node %s received %q, want %q", n.broker.nodeID, got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("node %s timed out waiting for %q", n.broker.nodeID, want)
+	}
+}
+
+// waitSubscribed 等待所有节点完成订阅，订阅之前发布的消息会丢失
+func waitSubscribed(t *testing.T, s *miniredis.Miniredis, nodeIDs ...string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		ok := s.PubSubNumPat() == 3*len(nodeIDs)
+		for _, id := range nodeIDs {
+			channel := testPrefix + "node:" + id
+			ok = ok && s.PubSubNumSub(channel)[channel] == 1
+		}
+		if ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for subscriptions")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRedisBrokerPublishSubscribe(t *testing.T) {
+	s := miniredis.RunT(t)
+
+	node1 := startRedisNode(t, s.Addr(), "node-1")
+	node2 := startRedisNode(t, s.Addr(), "node-2")
+	waitSubscribed(t, s, "node-1", "node-2")
+
+	// 直接订阅所有频道，检查每条消息发布到的频道
+	raw := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	defer raw.Close()
+	ps := raw.PSubscribe(context.Background(), testPrefix+"*")
+	defer ps.Close()
+	if _, err := ps.Receive(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// 命名空间广播、房间和单聊消息按 key 发布到各自的频道，所有节点都能收到（包括发布者自己）
+	for _, tc := range []struct{ key, message string }{
+		{"/chat", "broadcast"},
+		{"room:/chat:lobby", "room"},
+		{"dm:/chat:alice:bob", "direct"},
+	} {
+		node1.broker.SendMessage(tc.key, tc.message)
+
+		msg, err := ps.ReceiveMessage(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Channel != testPrefix+tc.key || msg.Payload != tc.message {
+			t.Fatalf("published %q to %q, want %q to %q", msg.Payload, msg.Channel, tc.message, testPrefix+tc.key)
+		}
+		node1.expect(t, tc.message)
+		node2.expect(t, tc.message)
+	}
+}
+
+func TestRedisBrokerSendToNode(t *testing.T) {
+	s := miniredis.RunT(t)
+	node1 := startRedisNode(t, s.Addr(), "node-1")
+	node2 := startRedisNode(t, s.Addr(), "node-2")
+	waitSubscribed(t, s, "node-1", "node-2")
+
+	node1.broker.SendToNode("node-2", "dm:/chat:alice:bob", "to node-2")
+	// 消息按发布顺序到达，node-1 收到的下一条是这条广播，说明它没有收到发给 node-2 的消息
+	node1.broker.SendMessage("/chat", "broadcast")
+
+	node2.expect(t, "to node-2")
+	node2.expect(t, "broadcast")
+	node1.expect(t, "broadcast")
+
+	node2.broker.SendToNode("node-1", "dm:/chat:bob:alice", "to node-1")
+	node1.expect(t, "to node-1")
+}
+
+// TestRedisBrokerRetriesSubscribe Redis 在开始消费时不可用，恢复后重新订阅并收到消息
+func TestRedisBrokerRetriesSubscribe(t *testing.T) {
+	s := miniredis.RunT(t)
+	rb, err := NewRedisBroker(storage.NewRedisStorage(s.Addr()), testPrefix, "node-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rb.retryInterval = 10 * time.Millisecond
+	s.Close()
+
+	received := make(chan string, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- rb.ConsumeMessages(func(message string) { received <- message })
+	}()
+	// 至少失败一次后再恢复
+	time.Sleep(50 * time.Millisecond)
+	if err := s.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	node2 := startRedisNode(t, s.Addr(), "node-2")
+	waitSubscribed(t, s, "node-1", "node-2")
+
+	node2.broker.SendToNode("node-1", "dm:/chat:alice:bob", "after restart")
+	select {
+	case got := <-received:
+		if got != "after restart" {
+			t.Fatalf("received %q, want %q", got, "after restart")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a message after Redis came back")
+	}
+
+	rb.Close()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ConsumeMessages did not return after Close")
+	}
+}
+
+// TestRedisBrokerCloseStopsRetry Redis 一直不可用时 Close 结束重试
+func TestRedisBrokerCloseStopsRetry(t *testing.T) {
+	s := miniredis.RunT(t)
+	rb, err := NewRedisBroker(storage.NewRedisStorage(s.Addr()), testPrefix, "node-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- rb.ConsumeMessages(func(string) {})
+	}()
+	time.Sleep(50 * time.Millisecond)
+	rb.Close()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ConsumeMessages did not return after Close")
+	}
+}
@@ -1,28 +1,120 @@
 package connection
 
 import (
+	"context"
+	"errors"
 	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/focusandinsist/go-ws-srv/internal/auth"
+	"github.com/focusandinsist/go-ws-srv/internal/metrics"
+	"github.com/focusandinsist/go-ws-srv/protocol"
+
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
+// SendPolicy 发送队列已满时的处理策略
+type SendPolicy int
+
+const (
+	DropOldest     SendPolicy = iota // 丢弃队列中最早的一帧
+	DropNewest                       // 丢弃当前要发送的帧
+	DisconnectSlow                   // 断开消费过慢的客户端
+)
+
+var (
+	// ErrQueueFull 发送队列已满，消息被丢弃
+	ErrQueueFull = errors.New("send queue full")
+	// ErrClientClosed 连接已关闭
+	ErrClientClosed = errors.New("client closed")
+)
+
+// Options 连接相关的参数
+type Options struct {
+	MaxDevices   int           // 每个用户最多同时在线的连接数，<=0 表示不限制
+	DevicePolicy DevicePolicy  // 超过设备数上限时的处理策略
+	QueueSize    int           // 每个连接的发送队列长度
+	SendPolicy   SendPolicy    // 发送队列已满时的处理策略
+	WriteTimeout time.Duration // 单次写 socket 的超时时间
+	PingInterval time.Duration // 发送 ping 的间隔
+	PongTimeout  time.Duration // 超过该时间未收到 pong 则断开连接
+}
+
+// DefaultOptions 默认的连接参数
+func DefaultOptions() Options {
+	return Options{
+		MaxDevices:   5,
+		DevicePolicy: KickOldest,
+		QueueSize:    256,
+		SendPolicy:   DropOldest,
+		WriteTimeout: 10 * time.Second,
+		PingInterval: 30 * time.Second,
+		PongTimeout:  60 * time.Second,
+	}
+}
+
+// frame 待写出的一帧
+type frame struct {
+	messageType int
+	data        []byte
+}
+
 // Client 代表单个 WebSocket 连接及其状态
 type Client struct {
-	Conn   *websocket.Conn // WebSocket 连接
-	UserID string          // 用户 ID
+	ID        string          // 连接 ID，每个连接唯一
+	Conn      *websocket.Conn // WebSocket 连接
+	UserID    string          // 用户 ID
+	DeviceID  string          // 设备 ID（可选），同一设备重复连接时会替换旧连接
+	Principal *auth.Principal // 握手时认证得到的身份
+	Codec     protocol.Codec  // 握手时协商的消息格式，默认 JSON
+
+	send         chan frame    // 发送队列，由 WritePump 单独写出
+	policy       SendPolicy    // 发送队列已满时的处理策略
+	writeTimeout time.Duration // 写超时
+	pingInterval time.Duration // ping 间隔
+	pongTimeout  time.Duration // pong 超时
+	done         chan struct{} // 连接关闭时关闭
+	ctx          context.Context
+	cancel       context.CancelFunc // 连接关闭时取消 ctx
+	closeOnce    sync.Once
+	closing      atomic.Bool // 关闭帧已进入发送队列，不再接受新消息
 
 	lastPong time.Time  // 上次收到 pong 的时间
-	mu       sync.Mutex // 保护并发写入和状态更新
+	mu       sync.Mutex // 保护状态更新
 }
 
 // NewClient 创建一个新的 Client 实例
-func NewClient(conn *websocket.Conn, userID string) *Client {
+func NewClient(conn *websocket.Conn, userID string, opts Options) *Client {
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = DefaultOptions().QueueSize
+	}
+	if opts.WriteTimeout <= 0 {
+		opts.WriteTimeout = DefaultOptions().WriteTimeout
+	}
+	if opts.PingInterval <= 0 {
+		opts.PingInterval = DefaultOptions().PingInterval
+	}
+	if opts.PongTimeout <= 0 {
+		opts.PongTimeout = DefaultOptions().PongTimeout
+	}
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Client{
-		Conn:     conn,
-		UserID:   userID,
-		lastPong: time.Now(),
+		ID:           uuid.NewString(),
+		Conn:         conn,
+		UserID:       userID,
+		Codec:        protocol.JSON,
+		send:         make(chan frame, opts.QueueSize),
+		policy:       opts.SendPolicy,
+		writeTimeout: opts.WriteTimeout,
+		pingInterval: opts.PingInterval,
+		pongTimeout:  opts.PongTimeout,
+		done:         make(chan struct{}),
+		ctx:          ctx,
+		cancel:       cancel,
+		lastPong:     time.Now(),
 	}
 }
 
@@ -36,26 +128,28 @@ func (c *Client) StartHeartbeat() {
 		return nil
 	})
 
-	ticker := time.NewTicker(30 * time.Second)
+	ticker := time.NewTicker(c.pingInterval)
 	defer ticker.Stop()
 
 	for {
 		select {
+		case <-c.done:
+			return
 		case <-ticker.C:
-			// 检查是否超时（例如超过 60 秒未收到 pong）
+			// 检查是否超过 pongTimeout 未收到 pong
 			c.mu.Lock()
-			if time.Since(c.lastPong) > 60*time.Second {
+			if time.Since(c.lastPong) > c.pongTimeout {
 				c.mu.Unlock()
 				log.Printf("Heartbeat timeout for client %s", c.UserID)
-				c.Conn.Close()
+				c.Close(websocket.CloseGoingAway, "heartbeat timeout")
 				return
 			}
 			c.mu.Unlock()
 
-			// 发送 ping 消息
-			if err := c.Conn.WriteMessage(websocket.PingMessage, []byte("ping")); err != nil {
+			// 发送 ping 消息，控制帧可以和 WritePump 并发写
+			if err := c.Conn.WriteControl(websocket.PingMessage, []byte("ping"), time.Now().Add(c.writeTimeout)); err != nil {
 				log.Printf("Error sending ping to client %s: %v", c.UserID, err)
-				c.Conn.Close()
+				c.Close(websocket.CloseGoingAway, "")
 				return
 			}
 		}
@@ -80,9 +174,177 @@ func (c *Client) ReadPump(handleFunc func(messageType int, data []byte)) {
 	}
 }
 
-// SendMessage 通过 WebSocket 发送消息给客户端
+// WritePump 串行写出发送队列中的消息，每个连接只能有一个 WritePump
+func (c *Client) WritePump() {
+	for {
+		select {
+		case <-c.done:
+			return
+		case f := <-c.send:
+			c.Conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+			if err := c.Conn.WriteMessage(f.messageType, f.data); err != nil {
+				log.Printf("Error writing to client %s: %v", c.UserID, err)
+				c.Close(websocket.CloseGoingAway, "")
+				return
+			}
+			// CloseAfterFlush 放入的关闭帧，之前排队的消息都已写出
+			if f.messageType == websocket.CloseMessage {
+				c.Close(websocket.CloseNormalClosure, "")
+				return
+			}
+		}
+	}
+}
+
+// SendMessage 将消息放入发送队列，由 WritePump 异步写出
+// 队列已满时按 SendPolicy 处理，不会阻塞调用方
 func (c *Client) SendMessage(messageType int, data []byte) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	return c.Conn.WriteMessage(messageType, data)
+	if c.closing.Load() {
+		return ErrClientClosed
+	}
+	f := frame{messageType: messageType, data: data}
+	for {
+		select {
+		case <-c.done:
+			return ErrClientClosed
+		case c.send <- f:
+			return nil
+		default:
+		}
+
+		switch c.policy {
+		case DropNewest:
+			metrics.OutboundDropped.Add(1)
+			return ErrQueueFull
+		case DisconnectSlow:
+			metrics.SlowConsumerDisconnects.Add(1)
+			log.Printf("Disconnecting slow client %s (conn %s)", c.UserID, c.ID)
+			// 写关闭帧可能阻塞到超时，不能占用调用方（通常是广播）的时间
+			go c.Close(websocket.CloseTryAgainLater, "slow consumer")
+			return ErrQueueFull
+		default:
+			// 丢掉最早的一帧后重试
+			select {
+			case <-c.send:
+				metrics.OutboundDropped.Add(1)
+			default:
+			}
+		}
+	}
+}
+
+// Emit 向客户端发送一个事件
+func (c *Client) Emit(event string, data any) error {
+	msg, err := protocol.NewMessage(event, data)
+	if err != nil {
+		return err
+	}
+	return c.SendEnvelope(msg)
+}
+
+// SendEnvelope 按连接的消息格式编码并发送一条完整的消息
+func (c *Client) SendEnvelope(msg *protocol.Message) error {
+	payload, err := c.Codec.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return c.SendMessage(c.FrameType(), payload)
+}
+
+// SendPush 发送通过 REST 推送的原始消息
+// JSON 连接原样收到文本帧；Protobuf 连接只能解析 Message，推送内容作为 push 事件的 Data 发送
+func (c *Client) SendPush(data []byte) error {
+	if !c.Codec.Binary() {
+		return c.SendMessage(websocket.TextMessage, data)
+	}
+	msg, err := protocol.NewMessage(protocol.EventPush, string(data))
+	if err != nil {
+		return err
+	}
+	return c.SendEnvelope(msg)
+}
+
+// FrameType 发送完整消息时使用的帧类型，Protobuf 使用二进制帧
+func (c *Client) FrameType() int {
+	if c.Codec.Binary() {
+		return websocket.BinaryMessage
+	}
+	return websocket.TextMessage
+}
+
+// EmitWithAck 发送事件并等待客户端用同一个 AckID 回复 __ack__，返回 ack 消息（Data 为回复内容）
+// ctx 取消、超时或连接关闭时返回错误
+func (c *Client) EmitWithAck(ctx context.Context, event string, data any) (*protocol.Message, error) {
+	msg, err := protocol.NewMessage(event, data)
+	if err != nil {
+		return nil, err
+	}
+	msg.Ack = true
+	msg.AckID = uuid.NewString()
+
+	// 先注册再发送，避免 ack 比注册先到
+	ch := protocol.AckManager.Register(c.ID, msg.AckID)
+	if err := c.SendEnvelope(msg); err != nil {
+		protocol.AckManager.Cancel(msg.AckID)
+		return nil, err
+	}
+	return protocol.AckManager.Wait(ctx, msg.AckID, ch)
+}
+
+// Ack 回复客户端发来的需要确认的消息，data 为回复内容；req 没有请求 ack 时不做任何事
+func (c *Client) Ack(req *protocol.Message, data any) error {
+	if !req.Ack || req.AckID == "" {
+		return nil
+	}
+	msg, err := protocol.NewMessage(protocol.EventAck, data)
+	if err != nil {
+		return err
+	}
+	msg.Namespace = req.Namespace
+	msg.AckID = req.AckID
+	return c.SendEnvelope(msg)
+}
+
+// QueueDepth 当前发送队列中的帧数
+func (c *Client) QueueDepth() int {
+	return len(c.send)
+}
+
+// Done 连接关闭时返回的 channel 会被关闭
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Context 连接关闭时取消的 context，用于中止为这个连接进行的耗时操作
+func (c *Client) Context() context.Context {
+	return c.ctx
+}
+
+// CloseAfterFlush 把关闭帧放到发送队列末尾，WritePump 写完之前排队的消息后再发送关闭帧并关闭连接
+// 调用之后 SendMessage 返回 ErrClientClosed；队列已满时直接关闭
+func (c *Client) CloseAfterFlush(code int, reason string) {
+	if !c.closing.CompareAndSwap(false, true) {
+		return
+	}
+	f := frame{messageType: websocket.CloseMessage, data: websocket.FormatCloseMessage(code, reason)}
+	select {
+	case c.send <- f:
+	default:
+		go c.Close(code, reason)
+	}
+}
+
+// Close 发送关闭帧后关闭连接，可重复调用
+func (c *Client) Close(code int, reason string) error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.cancel()
+		// 唤醒所有还在等待这个连接回复 ack 的调用方
+		protocol.AckManager.CancelOwner(c.ID)
+		deadline := time.Now().Add(time.Second)
+		_ = c.Conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), deadline)
+		err = c.Conn.Close()
+	})
+	return err
 }
@@ -4,113 +4,253 @@
 package connection
 
 import (
+	"errors"
 	"fmt"
 	"log"
-	"sync"
+	"slices"
+	"sync/atomic"
+
+	"github.com/focusandinsist/go-ws-srv/internal/metrics"
+
+	"github.com/gorilla/websocket"
+)
+
+// DevicePolicy 用户连接数达到上限时的处理策略
+type DevicePolicy int
+
+const (
+	KickOldest   DevicePolicy = iota // 踢掉最早建立的连接
+	RejectNewest                     // 拒绝新连接
+)
+
+var (
+	// ErrDeviceLimit 用户连接数已达上限
+	ErrDeviceLimit = errors.New("device limit reached")
+	// ErrUserNotFound 用户（或指定的设备）在本节点没有连接
+	ErrUserNotFound = errors.New("user not found")
 )
 
 // ConnectionManager 管理所有连接的 WebSocket 客户端
+// 每个连接有独立的 ID，同一用户可以同时在多个设备上在线
+// 连接按连接 ID 分片、用户索引按用户 ID 分片，避免所有操作争用一把全局锁；同时持有两种锁时先锁用户分片
 type ConnectionManager struct {
-	clients map[string]*Client
-	mu      sync.Mutex
+	conns ClientSet
+	users [shardCount]userShard
+	count atomic.Int64 // 当前连接数
+	opts  Options
 }
 
 // NewConnectionManager 创建连接管理器
-func NewConnectionManager() *ConnectionManager {
-	return &ConnectionManager{
-		clients: make(map[string]*Client),
+func NewConnectionManager(opts Options) *ConnectionManager {
+	cm := &ConnectionManager{opts: opts}
+	for i := range cm.users {
+		cm.users[i].users = make(map[string][]*Client)
 	}
+	metrics.SetQueueStats(cm.queueStats)
+	return cm
+}
+
+// NewClient 按连接管理器的参数创建一个新的 Client
+func (cm *ConnectionManager) NewClient(conn *websocket.Conn, userID string) *Client {
+	return NewClient(conn, userID, cm.opts)
+}
+
+// queueStats 统计所有连接的发送队列
+func (cm *ConnectionManager) queueStats() metrics.QueueStats {
+	var stats metrics.QueueStats
+	cm.ForEach(func(c *Client) {
+		depth := c.QueueDepth()
+		stats.Connections++
+		stats.Queued += depth
+		stats.MaxDepth = max(stats.MaxDepth, depth)
+	})
+	return stats
+}
+
+func (cm *ConnectionManager) userShard(userID string) *userShard {
+	return &cm.users[shardIndex(userID)]
 }
 
 // AddClient 添加新客户端
-func (cm *ConnectionManager) AddClient(client *Client) {
-	cm.mu.Lock()
-	defer cm.mu.Unlock()
-	cm.clients[client.UserID] = client
+// 同一设备重复连接时替换旧连接；超过设备数上限时按 policy 踢掉最早的连接或返回 ErrDeviceLimit
+func (cm *ConnectionManager) AddClient(client *Client) error {
+	us := cm.userShard(client.UserID)
+	us.mu.Lock()
+	var kicked []*Client
+	conns := us.users[client.UserID]
+	if client.DeviceID != "" {
+		kept := conns[:0:0]
+		for _, c := range conns {
+			if c.DeviceID == client.DeviceID {
+				kicked = append(kicked, c)
+			} else {
+				kept = append(kept, c)
+			}
+		}
+		conns = kept
+	}
+	if cm.opts.MaxDevices > 0 && len(conns) >= cm.opts.MaxDevices {
+		if cm.opts.DevicePolicy == RejectNewest {
+			us.mu.Unlock()
+			return ErrDeviceLimit
+		}
+		kicked = append(kicked, conns[:len(conns)-cm.opts.MaxDevices+1]...)
+	}
+	for _, c := range kicked {
+		cm.removeLocked(us, c)
+	}
+	cm.conns.Add(client)
+	us.users[client.UserID] = append(us.users[client.UserID], client)
+	cm.count.Add(1)
+	us.mu.Unlock()
+
+	// 在锁外关闭被踢掉的连接
+	for _, c := range kicked {
+		log.Printf("Kicking connection %s of user %s", c.ID, c.UserID)
+		c.Close(websocket.ClosePolicyViolation, "replaced by a newer connection")
+	}
+	return nil
 }
 
-// RemoveClient 移除客户端
+// RemoveClient 移除客户端，只会移除这个连接本身，不影响同一用户的其他连接
 func (cm *ConnectionManager) RemoveClient(client *Client) {
-	cm.mu.Lock()
-	defer cm.mu.Unlock()
-	delete(cm.clients, client.UserID)
+	us := cm.userShard(client.UserID)
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	cm.removeLocked(us, client)
+}
+
+// removeLocked 调用方需持有 us.mu
+func (cm *ConnectionManager) removeLocked(us *userShard, client *Client) {
+	if !cm.conns.Remove(client) {
+		return
+	}
+	us.removeLocked(client)
+	cm.count.Add(-1)
+}
+
+// GetClient 根据连接 ID 获取连接
+func (cm *ConnectionManager) GetClient(connID string) *Client {
+	return cm.conns.Get(connID)
+}
+
+// GetUserClients 获取特定用户的所有连接
+func (cm *ConnectionManager) GetUserClients(userID string) []*Client {
+	us := cm.userShard(userID)
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	return slices.Clone(us.users[userID])
+}
+
+// IsOnline 判断用户是否至少有一个连接在线
+func (cm *ConnectionManager) IsOnline(userID string) bool {
+	us := cm.userShard(userID)
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	return len(us.users[userID]) > 0
+}
+
+// Count 当前连接数
+func (cm *ConnectionManager) Count() int {
+	return int(cm.count.Load())
 }
 
-// GetClient 获取特定用户的连接
-func (cm *ConnectionManager) GetClient(userID string) *Client {
-	cm.mu.Lock()
-	defer cm.mu.Unlock()
-	return cm.clients[userID]
+// ForEach 无锁遍历所有连接的快照，用于广播
+// 遍历期间新建立的连接可能不在其中，已关闭的连接可能仍在其中（发送会返回 ErrClientClosed）
+func (cm *ConnectionManager) ForEach(fn func(*Client)) {
+	cm.conns.ForEach(fn)
 }
 
 // GetAllClients 获取所有连接的客户端
 func (cm *ConnectionManager) GetAllClients() []*Client {
-	cm.mu.Lock()
-	defer cm.mu.Unlock()
-
-	clients := make([]*Client, 0, len(cm.clients))
-	for _, client := range cm.clients {
-		clients = append(clients, client)
-	}
+	clients := make([]*Client, 0, cm.Count())
+	cm.ForEach(func(c *Client) {
+		clients = append(clients, c)
+	})
 	return clients
 }
 
-// CloseConnection 关闭单个连接
+// CloseConnection 关闭用户的所有连接
 func (cm *ConnectionManager) CloseConnection(userID string) error {
-	cm.mu.Lock()
-	defer cm.mu.Unlock()
+	us := cm.userShard(userID)
+	us.mu.Lock()
+	conns := slices.Clone(us.users[userID])
+	for _, c := range conns {
+		cm.removeLocked(us, c)
+	}
+	us.mu.Unlock()
 
 	// 检查连接是否存在
-	targetClient, exists := cm.clients[userID]
-	if !exists {
+	if len(conns) == 0 {
 		return fmt.Errorf("connection not found")
 	}
 
 	// 关闭连接
-	err := targetClient.Conn.Close()
-	if err != nil {
-		log.Printf("Error closing connection: %v", err)
-		return err
+	for _, c := range conns {
+		if err := c.Close(websocket.CloseNormalClosure, ""); err != nil {
+			log.Printf("Error closing connection: %v", err)
+		}
 	}
-
-	// 移除该连接
-	delete(cm.clients, userID)
 	log.Printf("Connection closed: %s", userID)
 	return nil
 }
 
 // CloseAllConnections 关闭所有连接
 func (cm *ConnectionManager) CloseAllConnections() {
-	cm.mu.Lock()
-	defer cm.mu.Unlock()
-
-	for _, client := range cm.clients {
-		err := client.Conn.Close()
+	for i := range cm.users {
+		us := &cm.users[i]
+		us.mu.Lock()
+		clear(us.users)
+		us.mu.Unlock()
+	}
+	for _, client := range cm.conns.Clear() {
+		cm.count.Add(-1)
+		err := client.Close(websocket.CloseGoingAway, "server shutting down")
 		if err != nil {
 			log.Printf("Error closing connection: %v", err)
 		}
 	}
-	clear(cm.clients) // 清空所有连接
 }
 
 // GetAllUserIDs 获取所有在线用户ID
 func (cm *ConnectionManager) GetAllUserIDs() []string {
-	cm.mu.Lock()
-	defer cm.mu.Unlock()
-	userIDs := make([]string, 0, len(cm.clients))
-	for id := range cm.clients {
-		userIDs = append(userIDs, id)
+	var userIDs []string
+	for i := range cm.users {
+		us := &cm.users[i]
+		us.mu.Lock()
+		for id := range us.users {
+			userIDs = append(userIDs, id)
+		}
+		us.mu.Unlock()
 	}
 	return userIDs
 }
 
-// SendMessageToUser 向指定用户发送消息
+// SendMessageToUser 向指定用户的所有设备推送原始消息，只要有一个设备发送成功即返回 nil，格式见 Client.SendPush
 func (cm *ConnectionManager) SendMessageToUser(userID string, data []byte) error {
-	cm.mu.Lock()
-	client, ok := cm.clients[userID]
-	cm.mu.Unlock()
-	if !ok {
-		return fmt.Errorf("user %s not found", userID)
+	conns := cm.GetUserClients(userID)
+	if len(conns) == 0 {
+		return fmt.Errorf("%w: %s", ErrUserNotFound, userID)
+	}
+
+	var errs []error
+	for _, c := range conns {
+		if err := c.SendPush(data); err != nil {
+			errs = append(errs, fmt.Errorf("connection %s: %w", c.ID, err))
+		}
+	}
+	if len(errs) == len(conns) {
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+// SendMessageToDevice 向指定用户的某个设备推送原始消息，格式见 Client.SendPush
+func (cm *ConnectionManager) SendMessageToDevice(userID, deviceID string, data []byte) error {
+	for _, c := range cm.GetUserClients(userID) {
+		if c.DeviceID == deviceID {
+			return c.SendPush(data)
+		}
 	}
-	return client.SendMessage(1, data) // 你用的是 TextMessage 就写 1
+	return fmt.Errorf("%w: device %s of user %s", ErrUserNotFound, deviceID, userID)
 }
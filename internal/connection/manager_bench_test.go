@@ -0,0 +1,193 @@
+package connection
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// 对比分片的 ConnectionManager 与分片之前的实现：
+//
+//	go test -run '^$' -bench . ./internal/connection
+
+// baselineManager 分片之前的 ConnectionManager（基线提交 b57101e），方法体原样保留，只改了类型名
+// 一把全局锁，以用户 ID 为 key，广播时在锁内复制整个 map
+type baselineManager struct {
+	clients map[string]*Client
+	mu      sync.Mutex
+}
+
+func newBaselineManager() *baselineManager {
+	return &baselineManager{
+		clients: make(map[string]*Client),
+	}
+}
+
+// AddClient 添加新客户端
+func (cm *baselineManager) AddClient(client *Client) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.clients[client.UserID] = client
+}
+
+// RemoveClient 移除客户端
+func (cm *baselineManager) RemoveClient(client *Client) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	delete(cm.clients, client.UserID)
+}
+
+// GetClient 获取特定用户的连接
+func (cm *baselineManager) GetClient(userID string) *Client {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	return cm.clients[userID]
+}
+
+// GetAllClients 获取所有连接的客户端
+func (cm *baselineManager) GetAllClients() []*Client {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	clients := make([]*Client, 0, len(cm.clients))
+	for _, client := range cm.clients {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+// registry 两种实现共有的操作；每个连接属于不同的用户，所以按连接查找和按用户查找等价
+type registry interface {
+	add(*Client)
+	remove(*Client)
+	lookup(*Client) *Client
+	forEach(func(*Client))
+}
+
+type baselineRegistry struct{ *baselineManager }
+
+func (r baselineRegistry) add(c *Client)            { r.AddClient(c) }
+func (r baselineRegistry) remove(c *Client)         { r.RemoveClient(c) }
+func (r baselineRegistry) lookup(c *Client) *Client { return r.GetClient(c.UserID) }
+func (r baselineRegistry) forEach(fn func(*Client)) {
+	// 原来的广播先取出全部连接再逐个发送
+	for _, c := range r.GetAllClients() {
+		fn(c)
+	}
+}
+
+type shardedRegistry struct{ *ConnectionManager }
+
+func (r shardedRegistry) add(c *Client)            { r.AddClient(c) }
+func (r shardedRegistry) remove(c *Client)         { r.RemoveClient(c) }
+func (r shardedRegistry) lookup(c *Client) *Client { return r.GetClient(c.ID) }
+func (r shardedRegistry) forEach(fn func(*Client)) { r.ForEach(fn) }
+
+var registries = []struct {
+	name string
+	new  func() registry
+}{
+	{"mutex", func() registry { return baselineRegistry{newBaselineManager()} }},
+	{"sharded", func() registry { return shardedRegistry{NewConnectionManager(Options{QueueSize: 1})} }},
+}
+
+// benchConnections 查找和广播时的连接数
+const benchConnections = 100000
+
+// benchClients 各个基准共用的连接，每个连接属于不同的用户
+var benchClients = sync.OnceValue(func() []*Client {
+	return newBenchClients("user-", benchConnections)
+})
+
+func newBenchClients(prefix string, n int) []*Client {
+	clients := make([]*Client, n)
+	for i := range clients {
+		clients[i] = NewClient(nil, prefix+strconv.Itoa(i), Options{QueueSize: 1})
+	}
+	return clients
+}
+
+func populate(r registry, clients []*Client) {
+	for _, c := range clients {
+		r.add(c)
+	}
+}
+
+// BenchmarkChurn 并发建立和断开连接
+func BenchmarkChurn(b *testing.B) {
+	clients := newBenchClients("churn-", 4096)
+	for _, reg := range registries {
+		b.Run(reg.name, func(b *testing.B) {
+			r := reg.new()
+			var worker atomic.Int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				// 每个 goroutine 使用不同的连接，避免互相删除对方刚加入的连接
+				base := int(worker.Add(1)) * 64
+				for i := 0; pb.Next(); i++ {
+					c := clients[(base+i%64)%len(clients)]
+					r.add(c)
+					r.remove(c)
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkLookup 并发查找连接
+func BenchmarkLookup(b *testing.B) {
+	clients := benchClients()
+	for _, reg := range registries {
+		b.Run(reg.name, func(b *testing.B) {
+			r := reg.new()
+			populate(r, clients)
+			var worker atomic.Int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				base := int(worker.Add(1)) * 7919
+				for i := 0; pb.Next(); i++ {
+					r.lookup(clients[(base+i)%len(clients)])
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkBroadcast 在有连接持续建立和断开的情况下遍历所有连接
+func BenchmarkBroadcast(b *testing.B) {
+	clients := benchClients()
+	churn := newBenchClients("churn-", 1024)
+	for _, reg := range registries {
+		b.Run(reg.name, func(b *testing.B) {
+			r := reg.new()
+			populate(r, clients)
+
+			stop := make(chan struct{})
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; ; i++ {
+					select {
+					case <-stop:
+						return
+					default:
+					}
+					c := churn[i%len(churn)]
+					r.add(c)
+					r.remove(c)
+				}
+			}()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				n := 0
+				r.forEach(func(*Client) { n++ })
+			}
+			b.StopTimer()
+			close(stop)
+			wg.Wait()
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package connection
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// shardCount 分片数，必须是 2 的幂
+const shardCount = 64
+
+// connShard 按连接 ID 分片保存连接
+// snapshot 是只读的连接列表快照，广播时无锁遍历；分片内容变化时置空，下次遍历时重建
+type connShard struct {
+	mu       sync.RWMutex
+	clients  map[string]*Client
+	snapshot atomic.Pointer[[]*Client]
+}
+
+// ClientSet 按连接 ID 分片的连接集合，零值可以直接使用
+// 遍历时使用各分片的只读快照，不会与加入、离开争用同一把锁，用于广播
+type ClientSet struct {
+	shards [shardCount]connShard
+}
+
+func (s *ClientSet) shard(connID string) *connShard {
+	return &s.shards[shardIndex(connID)]
+}
+
+// Add 加入连接，已有同 ID 的连接时替换
+func (s *ClientSet) Add(c *Client) {
+	s.shard(c.ID).add(c)
+}
+
+// Remove 移除连接，只有集合中保存的正是 c 时才移除
+func (s *ClientSet) Remove(c *Client) bool {
+	return s.shard(c.ID).remove(c)
+}
+
+// Get 按连接 ID 查找，不存在时返回 nil
+func (s *ClientSet) Get(connID string) *Client {
+	return s.shard(connID).get(connID)
+}
+
+// ForEach 无锁遍历所有连接的快照
+// 遍历期间新加入的连接可能不在其中，已移除的连接可能仍在其中
+func (s *ClientSet) ForEach(fn func(*Client)) {
+	for i := range s.shards {
+		for _, c := range s.shards[i].list() {
+			fn(c)
+		}
+	}
+}
+
+// Clear 清空集合并返回被移除的连接
+func (s *ClientSet) Clear() []*Client {
+	var clients []*Client
+	for i := range s.shards {
+		clients = append(clients, s.shards[i].clear()...)
+	}
+	return clients
+}
+
+// userShard 按用户 ID 分片保存用户的连接列表，按建立顺序
+type userShard struct {
+	mu    sync.Mutex
+	users map[string][]*Client
+}
+
+// shardIndex 计算 key 所在的分片，FNV-1a
+func shardIndex(key string) int {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return int(h & (shardCount - 1))
+}
+
+func (s *connShard) add(c *Client) {
+	s.mu.Lock()
+	if s.clients == nil {
+		s.clients = make(map[string]*Client)
+	}
+	s.clients[c.ID] = c
+	s.snapshot.Store(nil)
+	s.mu.Unlock()
+}
+
+// remove 只有当前保存的正是 c 时才删除
+func (s *connShard) remove(c *Client) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.clients[c.ID] != c {
+		return false
+	}
+	delete(s.clients, c.ID)
+	s.snapshot.Store(nil)
+	return true
+}
+
+func (s *connShard) get(id string) *Client {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.clients[id]
+}
+
+// list 返回分片的快照，调用方不能修改返回的切片
+func (s *connShard) list() []*Client {
+	if p := s.snapshot.Load(); p != nil {
+		return *p
+	}
+
+	// 在读锁内重建并保存快照，保证不会覆盖写操作之后的置空
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if p := s.snapshot.Load(); p != nil {
+		return *p
+	}
+	clients := make([]*Client, 0, len(s.clients))
+	for _, c := range s.clients {
+		clients = append(clients, c)
+	}
+	s.snapshot.Store(&clients)
+	return clients
+}
+
+// clear 清空分片并返回被移除的连接
+func (s *connShard) clear() []*Client {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clients := make([]*Client, 0, len(s.clients))
+	for _, c := range s.clients {
+		clients = append(clients, c)
+	}
+	clear(s.clients)
+	s.snapshot.Store(nil)
+	return clients
+}
+
+// removeLocked 从用户的连接列表中移除 c，调用方需持有 mu
+func (s *userShard) removeLocked(c *Client) {
+	conns := slices.DeleteFunc(s.users[c.UserID], func(x *Client) bool { return x == c })
+	if len(conns) == 0 {
+		delete(s.users, c.UserID)
+	} else {
+		s.users[c.UserID] = conns
+	}
+}
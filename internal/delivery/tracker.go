@@ -0,0 +1,171 @@
+// 可靠投递 (tracker.go)
+// 职责：对请求了 ack 的消息实现至少一次投递。消息按接收连接保存在待确认列表中，
+// 按指数退避重发直到收到 ack 或过期；连接断开时未确认的消息交给调用方转存离线队列。
+// 重发使用相同的消息 ID，客户端需要按 ID 去重。
+package delivery
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/focusandinsist/go-ws-srv/internal/connection"
+	"github.com/focusandinsist/go-ws-srv/protocol"
+
+	"github.com/google/uuid"
+)
+
+// Options 重发参数
+type Options struct {
+	InitialBackoff time.Duration // 第一次重发前的等待时间
+	MaxBackoff     time.Duration // 重发间隔上限
+	TTL            time.Duration // 消息从第一次发送起的最长等待确认时间
+}
+
+// DefaultOptions 默认的重发参数
+func DefaultOptions() Options {
+	return Options{
+		InitialBackoff: time.Second,
+		MaxBackoff:     30 * time.Second,
+		TTL:            2 * time.Minute,
+	}
+}
+
+type pending struct {
+	client   *connection.Client
+	msg      *protocol.Message
+	payload  []byte
+	backoff  time.Duration
+	attempts int
+	expires  time.Time
+	timer    *time.Timer
+}
+
+// Tracker 保存所有连接上等待确认的消息
+type Tracker struct {
+	opts    Options
+	pending map[string]map[string]*pending // 连接 ID -> ackID -> 待确认消息
+	mu      sync.Mutex
+}
+
+// NewTracker 创建 Tracker
+func NewTracker(opts Options) *Tracker {
+	return &Tracker{
+		opts:    opts,
+		pending: make(map[string]map[string]*pending),
+	}
+}
+
+// Send 发送消息并在收到 ack 前按退避策略重发
+// 消息没有 ID 时会生成一个，AckID 与消息 ID 相同，重发时保持不变
+func (t *Tracker) Send(client *connection.Client, msg *protocol.Message) error {
+	if msg.ID == "" {
+		msg.ID = uuid.NewString()
+	}
+	out := *msg
+	out.Ack = true
+	out.AckID = msg.ID
+	payload, err := client.Codec.Marshal(&out)
+	if err != nil {
+		return err
+	}
+
+	p := &pending{
+		client:  client,
+		msg:     &out,
+		payload: payload,
+		backoff: t.opts.InitialBackoff,
+		expires: time.Now().Add(t.opts.TTL),
+	}
+
+	t.mu.Lock()
+	byAck, ok := t.pending[client.ID]
+	if !ok {
+		byAck = make(map[string]*pending)
+		t.pending[client.ID] = byAck
+	}
+	if old, dup := byAck[out.AckID]; dup {
+		old.timer.Stop()
+	}
+	byAck[out.AckID] = p
+	p.timer = time.AfterFunc(p.backoff, func() { t.retransmit(client.ID, out.AckID) })
+	t.mu.Unlock()
+
+	if err := client.SendMessage(client.FrameType(), payload); err == connection.ErrClientClosed {
+		// 连接已经关闭，可能错过了 Drain，交给调用方处理
+		t.Ack(client.ID, out.AckID)
+		return err
+	}
+	return nil
+}
+
+// Ack 收到确认，返回 ackID 是否在该连接的待确认列表中
+func (t *Tracker) Ack(connID, ackID string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, ok := t.pending[connID][ackID]
+	if !ok {
+		return false
+	}
+	p.timer.Stop()
+	t.removeLocked(connID, ackID)
+	return true
+}
+
+// Drain 连接断开时取出该连接上所有未确认的消息
+func (t *Tracker) Drain(connID string) []*protocol.Message {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	byAck := t.pending[connID]
+	msgs := make([]*protocol.Message, 0, len(byAck))
+	for _, p := range byAck {
+		p.timer.Stop()
+		msgs = append(msgs, p.msg)
+	}
+	delete(t.pending, connID)
+	return msgs
+}
+
+// Pending 当前等待确认的消息数
+func (t *Tracker) Pending() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := 0
+	for _, byAck := range t.pending {
+		n += len(byAck)
+	}
+	return n
+}
+
+func (t *Tracker) retransmit(connID, ackID string) {
+	t.mu.Lock()
+	p, ok := t.pending[connID][ackID]
+	if !ok {
+		t.mu.Unlock()
+		return
+	}
+	if time.Now().After(p.expires) {
+		t.removeLocked(connID, ackID)
+		t.mu.Unlock()
+		log.Printf("消息 %s 在 %d 次重发后仍未确认，放弃投递给用户 %s", p.msg.ID, p.attempts, p.client.UserID)
+		return
+	}
+	p.attempts++
+	p.backoff = min(p.backoff*2, t.opts.MaxBackoff)
+	p.timer = time.AfterFunc(p.backoff, func() { t.retransmit(connID, ackID) })
+	t.mu.Unlock()
+
+	// 连接已关闭时发送会失败，未确认的消息由 Drain 转存
+	if err := p.client.SendMessage(p.client.FrameType(), p.payload); err != nil {
+		log.Printf("重发消息 %s 给用户 %s 失败: %v", p.msg.ID, p.client.UserID, err)
+	}
+}
+
+func (t *Tracker) removeLocked(connID, ackID string) {
+	byAck := t.pending[connID]
+	delete(byAck, ackID)
+	if len(byAck) == 0 {
+		delete(t.pending, connID)
+	}
+}
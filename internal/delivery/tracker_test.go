@@ -0,0 +1,153 @@
+package delivery
+
+import (
+	"testing"
+	"time"
+
+	"github.com/focusandinsist/go-ws-srv/internal/connection"
+	"github.com/focusandinsist/go-ws-srv/protocol"
+)
+
+// newTestClient 没有底层连接的客户端，发送的帧留在发送队列中，QueueDepth 即发送次数
+func newTestClient() *connection.Client {
+	return connection.NewClient(nil, "bob", connection.Options{QueueSize: 1024})
+}
+
+// waitFor 轮询直到 cond 成立，超时则测试失败
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// assertNoSends 等待可能正在进行的重发完成后，确认之后不再有新的发送
+func assertNoSends(t *testing.T, c *connection.Client) {
+	t.Helper()
+	time.Sleep(20 * time.Millisecond)
+	before := c.QueueDepth()
+	time.Sleep(50 * time.Millisecond)
+	if after := c.QueueDepth(); after != before {
+		t.Fatalf("sent %d more frames, want none", after-before)
+	}
+}
+
+func TestTrackerRetransmitsUntilAcked(t *testing.T) {
+	tr := NewTracker(Options{InitialBackoff: 2 * time.Millisecond, MaxBackoff: 5 * time.Millisecond, TTL: time.Minute})
+	c := newTestClient()
+	msg := &protocol.Message{Event: "chat"}
+	if err := tr.Send(c, msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.ID == "" {
+		t.Fatal("Send did not assign a message ID")
+	}
+
+	waitFor(t, "two retransmissions", func() bool { return c.QueueDepth() >= 3 })
+	if !tr.Ack(c.ID, msg.ID) {
+		t.Fatal("Ack = false, want true")
+	}
+	if tr.Ack(c.ID, msg.ID) {
+		t.Fatal("second Ack = true, want false")
+	}
+	if n := tr.Pending(); n != 0 {
+		t.Fatalf("Pending = %d, want 0", n)
+	}
+	assertNoSends(t, c)
+}
+
+func TestTrackerStopsAfterTTL(t *testing.T) {
+	tr := NewTracker(Options{InitialBackoff: 2 * time.Millisecond, MaxBackoff: 4 * time.Millisecond, TTL: 20 * time.Millisecond})
+	c := newTestClient()
+	msg := &protocol.Message{ID: "m1", Event: "chat"}
+	if err := tr.Send(c, msg); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "the message to expire", func() bool { return tr.Pending() == 0 })
+	if c.QueueDepth() < 2 {
+		t.Fatalf("sent %d frames, want retransmissions before expiry", c.QueueDepth())
+	}
+	if tr.Ack(c.ID, msg.ID) {
+		t.Fatal("Ack after expiry = true, want false")
+	}
+	assertNoSends(t, c)
+}
+
+// TestTrackerDuplicateSend 同一 ID 再次发送时替换待确认的消息并停止旧的重发计时器
+func TestTrackerDuplicateSend(t *testing.T) {
+	tr := NewTracker(Options{InitialBackoff: time.Hour, MaxBackoff: time.Hour, TTL: time.Hour})
+	c := newTestClient()
+	if err := tr.Send(c, &protocol.Message{ID: "m1", Event: "chat"}); err != nil {
+		t.Fatal(err)
+	}
+	old := tr.pending[c.ID]["m1"]
+
+	if err := tr.Send(c, &protocol.Message{ID: "m1", Event: "chat", Data: []byte(`{"v":2}`)}); err != nil {
+		t.Fatal(err)
+	}
+	if n := tr.Pending(); n != 1 {
+		t.Fatalf("Pending = %d, want 1", n)
+	}
+	p := tr.pending[c.ID]["m1"]
+	if p == old {
+		t.Fatal("duplicate Send kept the old pending message")
+	}
+	if string(p.msg.Data) != `{"v":2}` {
+		t.Fatalf("pending data = %s, want the second message", p.msg.Data)
+	}
+	if old.timer.Stop() {
+		t.Fatal("old retransmit timer was still running")
+	}
+	if !tr.Ack(c.ID, "m1") {
+		t.Fatal("Ack = false, want true")
+	}
+	if p.timer.Stop() {
+		t.Fatal("retransmit timer still running after Ack")
+	}
+}
+
+func TestTrackerDrain(t *testing.T) {
+	tr := NewTracker(Options{InitialBackoff: time.Hour, MaxBackoff: time.Hour, TTL: time.Hour})
+	c := newTestClient()
+	other := newTestClient()
+	for _, id := range []string{"m1", "m2", "m3"} {
+		if err := tr.Send(c, &protocol.Message{ID: id, Event: "chat"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tr.Send(other, &protocol.Message{ID: "m4", Event: "chat"}); err != nil {
+		t.Fatal(err)
+	}
+	tr.Ack(c.ID, "m2")
+	var timers []*time.Timer
+	for _, p := range tr.pending[c.ID] {
+		timers = append(timers, p.timer)
+	}
+
+	got := map[string]bool{}
+	for _, msg := range tr.Drain(c.ID) {
+		if !msg.Ack || msg.AckID != msg.ID {
+			t.Errorf("drained message %s: ack = %v, ack_id = %q, want ack with its own ID", msg.ID, msg.Ack, msg.AckID)
+		}
+		got[msg.ID] = true
+	}
+	if len(got) != 2 || !got["m1"] || !got["m3"] {
+		t.Fatalf("Drain = %v, want m1 and m3", got)
+	}
+	for _, timer := range timers {
+		if timer.Stop() {
+			t.Fatal("retransmit timer still running after Drain")
+		}
+	}
+	if n := tr.Pending(); n != 1 {
+		t.Fatalf("Pending = %d, want only the other connection's message", n)
+	}
+	if msgs := tr.Drain(c.ID); len(msgs) != 0 {
+		t.Fatalf("second Drain = %d messages, want 0", len(msgs))
+	}
+}
@@ -7,33 +7,61 @@ import (
 	"github.com/focusandinsist/go-ws-srv/protocol"
 )
 
+// HandlerFunc 事件处理函数，返回的错误会发回给客户端
+type HandlerFunc func(client *connection.Client, msg *protocol.Message) error
+
+// Middleware 包装事件处理函数，可以在调用 next 前后执行逻辑；不调用 next 直接返回错误即可中断处理
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// Chain 用中间件包装 handler，mws[0] 在最外层，最先执行
+func Chain(handler HandlerFunc, mws ...Middleware) HandlerFunc {
+	for i := len(mws) - 1; i >= 0; i-- {
+		handler = mws[i](handler)
+	}
+	return handler
+}
+
 // EventManager 事件管理器
 type EventManager struct {
-	handlers map[string]func(*connection.Client, *protocol.Message)
-	mu       sync.RWMutex
+	handlers    map[string]HandlerFunc
+	middlewares []Middleware            // 所有事件共用的中间件
+	eventMws    map[string][]Middleware // 单个事件的中间件，在共用的中间件之后执行
+	mu          sync.RWMutex
 }
 
 // NewEventManager .
 func NewEventManager() *EventManager {
 	return &EventManager{
-		handlers: make(map[string]func(*connection.Client, *protocol.Message)),
+		handlers: make(map[string]HandlerFunc),
+		eventMws: make(map[string][]Middleware),
 	}
 }
 
-// Register 注册
-func (em *EventManager) Register(eventType string, handler func(*connection.Client, *protocol.Message)) {
+// Register 注册事件处理器，mws 只作用于该事件
+func (em *EventManager) Register(eventType string, handler HandlerFunc, mws ...Middleware) {
 	em.mu.Lock()
 	defer em.mu.Unlock()
 	em.handlers[eventType] = handler
+	em.eventMws[eventType] = mws
 }
 
-// Trigger 触发
-func (em *EventManager) Trigger(eventType string, client *connection.Client, msg *protocol.Message) {
+// Use 添加所有事件共用的中间件，按添加顺序执行
+func (em *EventManager) Use(mws ...Middleware) {
+	em.mu.Lock()
+	defer em.mu.Unlock()
+	em.middlewares = append(em.middlewares, mws...)
+}
+
+// Lookup 返回事件的处理器和需要依次执行的中间件（共用的在前），事件未注册时返回 false
+func (em *EventManager) Lookup(eventType string) (HandlerFunc, []Middleware, bool) {
 	em.mu.RLock()
+	defer em.mu.RUnlock()
 	handler, exists := em.handlers[eventType]
-	em.mu.RUnlock()
-
-	if exists {
-		handler(client, msg)
+	if !exists {
+		return nil, nil, false
 	}
+	mws := make([]Middleware, 0, len(em.middlewares)+len(em.eventMws[eventType]))
+	mws = append(mws, em.middlewares...)
+	mws = append(mws, em.eventMws[eventType]...)
+	return handler, mws, true
 }
@@ -0,0 +1,65 @@
+package event
+
+import (
+	"errors"
+	"log"
+	"runtime/debug"
+	"time"
+
+	"github.com/focusandinsist/go-ws-srv/internal/connection"
+	"github.com/focusandinsist/go-ws-srv/internal/metrics"
+	"github.com/focusandinsist/go-ws-srv/protocol"
+)
+
+// ErrInternal 处理器内部出错，不向客户端暴露细节
+var ErrInternal = errors.New("internal error")
+
+// Recover 捕获处理器中的 panic，记录堆栈后返回 ErrInternal，避免一个事件拖垮整个连接
+func Recover() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(client *connection.Client, msg *protocol.Message) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("命名空间 %s 事件 %s 的处理器 panic (user=%s conn=%s): %v\n%s", msg.Namespace, msg.Event, client.UserID, client.ID, r, debug.Stack())
+					err = ErrInternal
+				}
+			}()
+			return next(client, msg)
+		}
+	}
+}
+
+// Logging 记录每个事件的处理结果
+func Logging() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(client *connection.Client, msg *protocol.Message) error {
+			err := next(client, msg)
+			if err != nil {
+				log.Printf("命名空间 %s 事件 %s 处理失败 (user=%s conn=%s): %v", msg.Namespace, msg.Event, client.UserID, client.ID, err)
+			} else {
+				log.Printf("命名空间 %s 事件 %s 处理完成 (user=%s conn=%s)", msg.Namespace, msg.Event, client.UserID, client.ID)
+			}
+			return err
+		}
+	}
+}
+
+// Timing 按命名空间和事件统计处理次数和耗时，导出到 /metrics；超过 slow 的事件记录日志，slow <= 0 时不记录
+func Timing(slow time.Duration) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(client *connection.Client, msg *protocol.Message) error {
+			start := time.Now()
+			err := next(client, msg)
+			elapsed := time.Since(start)
+
+			// 不同命名空间可以注册同名事件，指标按 "命名空间 事件名" 分开统计
+			key := msg.Namespace + " " + msg.Event
+			metrics.EventCount.Add(key, 1)
+			metrics.EventDurationMicros.Add(key, elapsed.Microseconds())
+			if slow > 0 && elapsed > slow {
+				log.Printf("命名空间 %s 事件 %s 处理耗时 %v (user=%s conn=%s)", msg.Namespace, msg.Event, elapsed, client.UserID, client.ID)
+			}
+			return err
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/focusandinsist/go-ws-srv/internal/connection"
+	"github.com/focusandinsist/go-ws-srv/internal/presence"
+	"github.com/focusandinsist/go-ws-srv/protocol"
+	"github.com/focusandinsist/go-ws-srv/protocol/pb"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// presenceTimeout 单次查询或更新在线状态的超时时间
+const presenceTimeout = 2 * time.Second
+
+// 集群内转发的消息类型，决定接收节点如何投递
+const (
+	fanoutBroadcast = "broadcast" // 命名空间内的所有连接
+	fanoutDirect    = "direct"    // msg.ReceiverID 的所有连接
+	fanoutRoom      = "room"      // msg.Room 的所有成员
+	fanoutPush      = "push"      // msg.ReceiverID 的连接（或 Device 指定的设备），msg.Data 为推送内容，见 Push
+)
+
+// clusterEnvelope 节点之间通过 broker 转发的消息，总是以 Protobuf 编码，定义见 api/proto/message.proto
+type clusterEnvelope struct {
+	Node    string // 发布节点，用于过滤自己发出的消息
+	To      string // 目标节点，为空表示所有节点
+	Kind    string
+	Device  string // push 的目标设备，为空表示用户的所有设备
+	Message *protocol.Message
+}
+
+func (e *clusterEnvelope) marshal() ([]byte, error) {
+	return proto.Marshal(&pb.ClusterEnvelope{
+		Node:    e.Node,
+		To:      e.To,
+		Kind:    e.Kind,
+		Device:  e.Device,
+		Message: protocol.ToProto(e.Message),
+	})
+}
+
+func (e *clusterEnvelope) unmarshal(b []byte) error {
+	var pe pb.ClusterEnvelope
+	if err := proto.Unmarshal(b, &pe); err != nil {
+		return err
+	}
+	if pe.Message == nil {
+		return errors.New("missing message")
+	}
+	*e = clusterEnvelope{
+		Node:    pe.Node,
+		To:      pe.To,
+		Kind:    pe.Kind,
+		Device:  pe.Device,
+		Message: protocol.FromProto(pe.Message),
+	}
+	return nil
+}
+
+// NodeID 当前节点的 ID
+func (h *Handler) NodeID() string {
+	return h.nodeID
+}
+
+// publish 把已经在本节点投递过的消息发布给其他节点，按会话作为 key 保证会话内有序
+func (h *Handler) publish(kind string, msg *protocol.Message) {
+	env := clusterEnvelope{Node: h.nodeID, Kind: kind, Message: msg}
+	b, err := env.marshal()
+	if err != nil {
+		log.Printf("编码集群消息失败: %v", err)
+		return
+	}
+	h.broker.SendMessage(clusterKey(msg), string(b))
+}
+
+// publishTo 把消息只发给指定节点
+func (h *Handler) publishTo(nodeID, kind string, msg *protocol.Message) {
+	env := clusterEnvelope{Node: h.nodeID, To: nodeID, Kind: kind, Message: msg}
+	b, err := env.marshal()
+	if err != nil {
+		log.Printf("编码集群消息失败: %v", err)
+		return
+	}
+	h.broker.SendToNode(nodeID, clusterKey(msg), string(b))
+}
+
+// clusterKey 发布消息的 key：会话 ID，没有会话的消息（广播）用命名空间，保证同一会话内有序
+func clusterKey(msg *protocol.Message) string {
+	if msg.ConvID != "" {
+		return msg.ConvID
+	}
+	return msg.Namespace
+}
+
+// routeDirect 按在线状态把单聊消息直接发给接收者所在的其他节点；查询失败时退回到发给所有节点
+func (h *Handler) routeDirect(msg *protocol.Message) {
+	ctx, cancel := context.WithTimeout(context.Background(), presenceTimeout)
+	defer cancel()
+	nodes, err := h.presence.Nodes(ctx, msg.ReceiverID)
+	if err != nil {
+		log.Printf("查询用户 %s 的在线状态失败，发给所有节点: %v", msg.ReceiverID, err)
+		h.publish(fanoutDirect, msg)
+		return
+	}
+	for _, nodeID := range nodes {
+		if nodeID != h.nodeID {
+			h.publishTo(nodeID, fanoutDirect, msg)
+		}
+	}
+}
+
+// Push 把原始消息推送给用户，deviceID 不为空时只推送给该设备，用户可以在集群内的任意节点上
+// 先发给本节点的连接，再按在线状态发给持有该用户连接的其他节点；用户不在线时返回 connection.ErrUserNotFound
+// 发给其他节点的推送只是转发，无法确认指定的设备在那个节点上
+func (h *Handler) Push(ctx context.Context, userID, deviceID string, data []byte) error {
+	sent, err := h.pushLocal(userID, deviceID, data)
+	if err != nil {
+		return err
+	}
+	nodes, err := h.presence.Nodes(ctx, userID)
+	if err != nil {
+		if sent {
+			log.Printf("查询用户 %s 的在线状态失败，只推送给本节点: %v", userID, err)
+			return nil
+		}
+		return err
+	}
+	msg := &protocol.Message{ReceiverID: userID, Data: data}
+	for _, nodeID := range nodes {
+		if nodeID == h.nodeID {
+			continue
+		}
+		// 以用户 ID 为 key，同一用户的推送保持顺序
+		env := clusterEnvelope{Node: h.nodeID, To: nodeID, Kind: fanoutPush, Device: deviceID, Message: msg}
+		b, err := env.marshal()
+		if err != nil {
+			return err
+		}
+		h.broker.SendToNode(nodeID, userID, string(b))
+		sent = true
+	}
+	if !sent {
+		return fmt.Errorf("%w: %s", connection.ErrUserNotFound, userID)
+	}
+	return nil
+}
+
+// pushLocal 把原始消息发给本节点上用户的连接，返回是否有连接收到；用户在本节点没有连接不算错误
+func (h *Handler) pushLocal(userID, deviceID string, data []byte) (bool, error) {
+	var err error
+	if deviceID != "" {
+		err = h.connMgr.SendMessageToDevice(userID, deviceID, data)
+	} else {
+		err = h.connMgr.SendMessageToUser(userID, data)
+	}
+	if errors.Is(err, connection.ErrUserNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// isOnline 用户在集群内是否有连接；在线状态不可用时只看本节点
+func (h *Handler) isOnline(userID string) bool {
+	if h.connMgr.IsOnline(userID) {
+		return true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), presenceTimeout)
+	defer cancel()
+	nodes, err := h.presence.Nodes(ctx, userID)
+	if err != nil {
+		log.Printf("查询用户 %s 的在线状态失败: %v", userID, err)
+		return false
+	}
+	return len(nodes) > 0
+}
+
+// registerPresence 在在线状态中登记本节点的连接
+func (h *Handler) registerPresence(client *connection.Client) {
+	ctx, cancel := context.WithTimeout(context.Background(), presenceTimeout)
+	defer cancel()
+	if err := h.presence.Register(ctx, h.nodeID, presenceConn(client)); err != nil {
+		log.Printf("登记用户 %s 的在线状态失败: %v", client.UserID, err)
+	}
+}
+
+// unregisterPresence 从在线状态中删除本节点的连接
+func (h *Handler) unregisterPresence(client *connection.Client) {
+	ctx, cancel := context.WithTimeout(context.Background(), presenceTimeout)
+	defer cancel()
+	if err := h.presence.Unregister(ctx, h.nodeID, presenceConn(client)); err != nil {
+		log.Printf("删除用户 %s 的在线状态失败: %v", client.UserID, err)
+	}
+}
+
+// PresenceConns 本节点当前的全部连接，用于在线状态心跳
+func (h *Handler) PresenceConns() []presence.Conn {
+	var conns []presence.Conn
+	h.connMgr.ForEach(func(c *connection.Client) {
+		conns = append(conns, presenceConn(c))
+	})
+	return conns
+}
+
+func presenceConn(c *connection.Client) presence.Conn {
+	return presence.Conn{UserID: c.UserID, ConnID: c.ID}
+}
+
+// ConsumeCluster 消费其他节点发布的消息并投递给本节点的连接，阻塞到 broker 关闭
+func (h *Handler) ConsumeCluster() error {
+	return h.broker.ConsumeMessages(h.handleClusterMessage)
+}
+
+// handleClusterMessage 投递其他节点发布的消息，只发给本节点持有的用户和房间成员
+func (h *Handler) handleClusterMessage(raw string) {
+	var env clusterEnvelope
+	if err := env.unmarshal([]byte(raw)); err != nil {
+		log.Printf("解析集群消息失败: %v", err)
+		return
+	}
+	if env.Node == h.nodeID || (env.To != "" && env.To != h.nodeID) {
+		return
+	}
+	msg := env.Message
+	// push 不属于任何命名空间
+	if env.Kind == fanoutPush {
+		if _, err := h.pushLocal(msg.ReceiverID, env.Device, msg.Data); err != nil {
+			log.Printf("推送消息给用户 %s 失败: %v", msg.ReceiverID, err)
+		}
+		return
+	}
+	if h.nsMgr.Get(msg.Namespace) == nil {
+		return
+	}
+
+	switch env.Kind {
+	case fanoutBroadcast:
+		h.broadcastLocal(msg)
+	case fanoutDirect:
+		h.directLocal(msg)
+	case fanoutRoom:
+		h.roomLocal(msg, "")
+	default:
+		log.Printf("未知的集群消息类型: %s", env.Kind)
+	}
+}
@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/focusandinsist/go-ws-srv/protocol"
+	"github.com/focusandinsist/go-ws-srv/protocol/pb"
+)
+
+// testEnvelope 每个字段都有非零值的集群消息
+func testEnvelope() *clusterEnvelope {
+	return &clusterEnvelope{
+		Node:   "node-a",
+		To:     "node-b",
+		Kind:   fanoutPush,
+		Device: "phone",
+		Message: &protocol.Message{
+			ID:         "msg-1",
+			Event:      "chat",
+			Namespace:  "/chat",
+			Ack:        true,
+			AckID:      "ack-1",
+			SenderID:   "alice",
+			ReceiverID: "bob",
+			Room:       "lobby",
+			ConvID:     "room:/chat:lobby",
+			Seq:        42,
+			Timestamp:  1760000000000,
+			Data:       json.RawMessage(`{"text":"hi"}`),
+		},
+	}
+}
+
+func TestClusterEnvelopeRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		env  *clusterEnvelope
+	}{
+		{"all fields", testEnvelope()},
+		{"broadcast", &clusterEnvelope{Node: "node-a", Kind: fanoutBroadcast, Message: &protocol.Message{Event: "chat"}}},
+		{"empty message", &clusterEnvelope{Node: "node-a", Kind: fanoutDirect, Message: &protocol.Message{}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := tt.env.marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got clusterEnvelope
+			if err := got.unmarshal(b); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(&got, tt.env) {
+				t.Fatalf("round trip = %+v (message %+v), want %+v (message %+v)", got, got.Message, tt.env, tt.env.Message)
+			}
+		})
+	}
+}
+
+// TestClusterEnvelopeProto 与生成的 pb.ClusterEnvelope 互相编解码，字段一一对应
+func TestClusterEnvelopeProto(t *testing.T) {
+	want := testEnvelope()
+	pe := &pb.ClusterEnvelope{
+		Node:    "node-a",
+		To:      "node-b",
+		Kind:    fanoutPush,
+		Device:  "phone",
+		Message: protocol.ToProto(want.Message),
+	}
+
+	b, err := proto.Marshal(pe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got clusterEnvelope
+	if err := got.unmarshal(b); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&got, want) {
+		t.Fatalf("unmarshal = %+v (message %+v), want %+v (message %+v)", got, got.Message, want, want.Message)
+	}
+
+	b, err = want.marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded pb.ClusterEnvelope
+	if err := proto.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(&decoded, pe) {
+		t.Fatalf("proto.Unmarshal(marshal()) = %v, want %v", &decoded, pe)
+	}
+}
+
+func TestClusterEnvelopeMalformed(t *testing.T) {
+	full, err := testEnvelope().marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing, err := proto.Marshal(&pb.ClusterEnvelope{Node: "node-a", Kind: fanoutDirect})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// ClusterEnvelope.message 的字段号为 4
+	nested := protowire.AppendTag(nil, 4, protowire.BytesType)
+	nested = protowire.AppendBytes(nested, []byte{0x80})
+
+	tests := []struct {
+		name string
+		b    []byte
+	}{
+		{"empty", nil},
+		{"truncated", full[:len(full)-1]},
+		{"truncated nested message", nested},
+		{"missing message", missing},
+		{"invalid wire type", []byte{1<<3 | 7}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var env clusterEnvelope
+			if err := env.unmarshal(tt.b); err == nil {
+				t.Fatalf("unmarshal(%x) = %+v, want error", tt.b, env)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/focusandinsist/go-ws-srv/internal/auth"
+	"github.com/focusandinsist/go-ws-srv/internal/connection"
+	"github.com/focusandinsist/go-ws-srv/internal/event"
+	"github.com/focusandinsist/go-ws-srv/internal/namespace"
+	"github.com/focusandinsist/go-ws-srv/protocol"
+)
+
+// defaultEventTimeout 单个事件处理器的默认最长执行时间
+const defaultEventTimeout = 10 * time.Second
+
+// Context 事件处理器的上下文：当前连接、请求、所在的命名空间，以及回复和发送消息的方法
+// 内嵌的 context.Context 在连接关闭或处理时间超过 event timeout 时取消，处理器返回后失效
+type Context struct {
+	context.Context
+	Client  *connection.Client
+	Message *protocol.Message
+
+	h      *Handler
+	ns     *namespace.Namespace
+	logger *log.Logger
+}
+
+// newContext 创建事件的上下文，处理器返回后调用 cancel 释放计时器
+func (h *Handler) newContext(client *connection.Client, msg *protocol.Message) (*Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(client.Context(), h.eventTimeout)
+	return &Context{
+		Context: ctx,
+		Client:  client,
+		Message: msg,
+		h:       h,
+		ns:      h.nsMgr.Of(msg.Namespace),
+	}, cancel
+}
+
+// SetEventTimeout 设置单个事件处理器的最长执行时间，需要在开始接受连接前调用
+func (h *Handler) SetEventTimeout(d time.Duration) {
+	if d > 0 {
+		h.eventTimeout = d
+	}
+}
+
+// WithContext 把使用 Context 的处理器转换成 event.HandlerFunc，用于 On 注册
+func (h *Handler) WithContext(fn func(ctx *Context) error) event.HandlerFunc {
+	return func(client *connection.Client, msg *protocol.Message) error {
+		ctx, cancel := h.newContext(client, msg)
+		defer cancel()
+		return fn(ctx)
+	}
+}
+
+// Principal 握手时认证得到的身份
+func (c *Context) Principal() *auth.Principal {
+	return c.Client.Principal
+}
+
+// UserID 当前用户 ID
+func (c *Context) UserID() string {
+	return c.Client.UserID
+}
+
+// Namespace 事件所在的命名空间
+func (c *Context) Namespace() *namespace.Namespace {
+	return c.ns
+}
+
+// Rooms 当前连接在这个命名空间内加入的房间
+func (c *Context) Rooms() []string {
+	return c.ns.Rooms().Rooms(c.Client.ID)
+}
+
+// Logger 带有用户、连接、命名空间和事件前缀的日志
+func (c *Context) Logger() *log.Logger {
+	if c.logger == nil {
+		prefix := fmt.Sprintf("[user=%s conn=%s ns=%s event=%s] ", c.Client.UserID, c.Client.ID, c.ns.Name, c.Message.Event)
+		c.logger = log.New(log.Writer(), prefix, log.Flags()|log.Lmsgprefix)
+	}
+	return c.logger
+}
+
+// Ack 回复请求，data 为 ack 的内容；请求没有要求 ack 时不做任何事
+func (c *Context) Ack(data any) error {
+	return c.Client.Ack(c.Message, data)
+}
+
+// Emit 向当前连接发送事件
+func (c *Context) Emit(eventType string, data any) error {
+	msg, err := protocol.NewMessage(eventType, data)
+	if err != nil {
+		return err
+	}
+	msg.Namespace = c.ns.Name
+	return c.Client.SendEnvelope(msg)
+}
+
+// Join 把当前连接加入房间
+func (c *Context) Join(room string) {
+	c.ns.Rooms().Join(room, c.Client.ID)
+}
+
+// Leave 把当前连接退出房间
+func (c *Context) Leave(room string) {
+	c.ns.Rooms().Leave(room, c.Client.ID)
+}
+
+// EmitToRoom 向房间内除当前连接外的所有成员发送事件，包括其他节点上的成员
+func (c *Context) EmitToRoom(room, eventType string, data any) error {
+	msg, err := c.newMessage(eventType, data)
+	if err != nil {
+		return err
+	}
+	msg.Room = room
+	c.h.roomLocal(msg, c.Client.ID)
+	c.h.publish(fanoutRoom, msg)
+	return nil
+}
+
+// EmitToUser 向用户在这个命名空间内的所有连接发送事件，包括其他节点上的连接
+func (c *Context) EmitToUser(userID, eventType string, data any) error {
+	msg, err := c.newMessage(eventType, data)
+	if err != nil {
+		return err
+	}
+	msg.ReceiverID = userID
+	c.h.directLocal(msg)
+	c.h.routeDirect(msg)
+	return nil
+}
+
+// Broadcast 向命名空间内的所有连接发送事件，包括当前连接和其他节点上的连接
+func (c *Context) Broadcast(eventType string, data any) error {
+	msg, err := c.newMessage(eventType, data)
+	if err != nil {
+		return err
+	}
+	c.h.broadcastLocal(msg)
+	c.h.publish(fanoutBroadcast, msg)
+	return nil
+}
+
+// newMessage 创建由当前用户发出、属于当前命名空间的消息
+func (c *Context) newMessage(eventType string, data any) (*protocol.Message, error) {
+	msg, err := protocol.NewMessage(eventType, data)
+	if err != nil {
+		return nil, err
+	}
+	msg.Namespace = c.ns.Name
+	stamp(msg, c.Client.UserID)
+	return msg, nil
+}
@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"log"
+
+	"github.com/focusandinsist/go-ws-srv/internal/connection"
+	"github.com/focusandinsist/go-ws-srv/internal/namespace"
+	"github.com/focusandinsist/go-ws-srv/protocol"
+)
+
+// isControlEvent 判断是否是控制帧：由服务端自己处理，不经过事件中间件，不保存到历史/离线队列，也不转发给其他节点
+func isControlEvent(eventType string) bool {
+	switch eventType {
+	case protocol.EventAck, protocol.EventPing,
+		EventConnect, EventDisconnect,
+		EventJoin, EventLeave,
+		EventSync:
+		return true
+	}
+	return false
+}
+
+// handleControl 处理控制帧，失败时向客户端回复错误
+func (h *Handler) handleControl(client *connection.Client, msg *protocol.Message) {
+	switch msg.Event {
+	case protocol.EventAck:
+		// 客户端对服务端 EmitWithAck 或可靠投递消息的回复
+		if !protocol.AckManager.Receive(client.ID, msg) && !h.delivery.Ack(client.ID, msg.AckID) {
+			log.Printf("用户 %s 回复了未知的 ack: %s", client.UserID, msg.AckID)
+		}
+		return
+	case protocol.EventPing:
+		// 应用层心跳，浏览器无法发送 WebSocket ping 帧
+		pong := &protocol.Message{Event: protocol.EventPong, Namespace: msg.Namespace, AckID: msg.AckID, Data: msg.Data}
+		if err := client.SendEnvelope(pong); err != nil {
+			log.Printf("回复用户 %s 的 pong 失败: %v", client.UserID, err)
+		}
+		return
+	// 加入/离开命名空间
+	case EventConnect:
+		h.connectNamespace(client, msg.Namespace)
+		return
+	case EventDisconnect:
+		if ns := h.nsMgr.Get(msg.Namespace); ns != nil {
+			ns.Disconnect(client)
+		}
+		return
+	}
+
+	// 房间和 sync 只能在已加入的命名空间内使用
+	if _, err := h.joinedNamespace(client, msg); err != nil {
+		h.replyError(client, msg, err)
+		return
+	}
+	var err error
+	switch msg.Event {
+	case EventJoin:
+		err = h.JoinRoom(client, msg)
+	case EventLeave:
+		err = h.LeaveRoom(client, msg)
+	case EventSync:
+		// 按会话补齐缺失的消息
+		err = h.Sync(client, msg)
+	}
+	if err != nil {
+		h.replyError(client, msg, err)
+	}
+}
+
+// joinedNamespace 返回消息所在的命名空间，连接尚未加入时返回 ErrNotConnected
+func (h *Handler) joinedNamespace(client *connection.Client, msg *protocol.Message) (*namespace.Namespace, error) {
+	ns := h.nsMgr.Get(msg.Namespace)
+	if ns == nil || !ns.Has(client.ID) {
+		log.Printf("用户 %s 未加入命名空间 %s，忽略事件 %s", client.UserID, msg.Namespace, msg.Event)
+		return nil, namespace.ErrNotConnected
+	}
+	return ns, nil
+}
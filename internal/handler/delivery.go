@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"errors"
+	"log"
+
+	"github.com/focusandinsist/go-ws-srv/internal/connection"
+	"github.com/focusandinsist/go-ws-srv/protocol"
+)
+
+// encodedFrames 发给多个连接的同一条消息，每种消息格式只编码一次
+type encodedFrames struct {
+	msg      *protocol.Message
+	payloads map[protocol.Codec][]byte
+}
+
+func newEncodedFrames(msg *protocol.Message) *encodedFrames {
+	return &encodedFrames{msg: msg, payloads: make(map[protocol.Codec][]byte, 2)}
+}
+
+// send 按目标连接的消息格式发送完整的消息
+func (f *encodedFrames) send(target *connection.Client) error {
+	payload, ok := f.payloads[target.Codec]
+	if !ok {
+		var err error
+		if payload, err = target.Codec.Marshal(f.msg); err != nil {
+			return err
+		}
+		f.payloads[target.Codec] = payload
+	}
+	return target.SendMessage(target.FrameType(), payload)
+}
+
+// deliver 将消息发给一个接收连接
+// 请求了 ack 的消息交给 delivery 可靠投递（重发直到收到 ack）；其余消息直接发送
+func (h *Handler) deliver(target *connection.Client, frames *encodedFrames) error {
+	msg := frames.msg
+	if !msg.Ack {
+		return frames.send(target)
+	}
+
+	err := h.delivery.Send(target, msg)
+	if errors.Is(err, connection.ErrClientClosed) {
+		h.storeOffline(target.UserID, msg)
+	}
+	return err
+}
+
+// ackSent 发送者请求了 ack 时回复消息 ID，在消息投递之后调用
+// 回复失败（连接正在关闭、发送队列已满）只记录日志：消息已经发出，作为处理失败返回会导致消息不被保存，
+// 发送者收到错误后还会用新的消息 ID 重发
+func ackSent(client *connection.Client, msg *protocol.Message) {
+	if err := client.Ack(msg, map[string]any{"id": msg.ID}); err != nil {
+		log.Printf("回复用户 %s 的 ack 失败: %v", client.UserID, err)
+	}
+}
+
+// storeOffline 将完整的消息存入用户的离线队列
+func (h *Handler) storeOffline(userID string, msg *protocol.Message) {
+	data, err := protocol.EncodeMessage(msg)
+	if err != nil {
+		log.Printf("编码离线消息失败: %v", err)
+		return
+	}
+	if err := h.offlineStore.AddOfflineMessage(userID, string(data)); err != nil {
+		log.Printf("保存用户 %s 的离线消息失败: %v", userID, err)
+	}
+}
@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math/rand/v2"
+	"time"
+
+	"github.com/focusandinsist/go-ws-srv/internal/connection"
+
+	"github.com/gorilla/websocket"
+)
+
+// forceCloseGrace 强制关闭连接后，等待连接清理（未确认消息转存离线队列）的最长时间
+const forceCloseGrace = 5 * time.Second
+
+// ReconnectHint 关闭帧 reason 中携带的重连提示，客户端应在 RetryAfterMs 毫秒后重连
+// 每个连接的等待时间随机分布，避免所有客户端同时重连到新节点
+type ReconnectHint struct {
+	Reconnect    bool  `json:"reconnect"`
+	RetryAfterMs int64 `json:"retry_after_ms"`
+}
+
+// beginConn 登记一个新连接，draining 之后返回 false
+func (h *Handler) beginConn() bool {
+	h.drainMu.Lock()
+	defer h.drainMu.Unlock()
+	if h.draining {
+		return false
+	}
+	h.active.Add(1)
+	return true
+}
+
+// Draining 是否已经开始停机
+func (h *Handler) Draining() bool {
+	h.drainMu.Lock()
+	defer h.drainMu.Unlock()
+	return h.draining
+}
+
+// closeIfDraining 在连接注册之后调用：握手在停机前通过了 beginConn，但注册晚于 Drain 遍历连接时，
+// 这个连接收不到关闭帧，这里补发 1012 和重连提示，避免停机一直等到超时
+func (h *Handler) closeIfDraining(c *connection.Client) {
+	h.drainMu.Lock()
+	draining, maxDelay := h.draining, h.drainDelay
+	h.drainMu.Unlock()
+	if draining {
+		c.CloseAfterFlush(websocket.CloseServiceRestart, reconnectReason(maxDelay))
+	}
+}
+
+// Drain 停止接受新连接，给每个客户端发送 1012 关闭帧和重连提示，
+// 然后等待发送队列写完、连接清理完成（包括未确认的消息转存离线队列）。
+// maxDelay 为客户端重连等待时间的上限；ctx 到期时强制关闭剩余连接并返回 ctx 的错误。
+func (h *Handler) Drain(ctx context.Context, maxDelay time.Duration) error {
+	h.drainMu.Lock()
+	h.draining = true
+	h.drainDelay = maxDelay
+	h.drainMu.Unlock()
+
+	log.Printf("Draining %d connections...", h.connMgr.Count())
+	h.connMgr.ForEach(func(c *connection.Client) {
+		c.CloseAfterFlush(websocket.CloseServiceRestart, reconnectReason(maxDelay))
+	})
+
+	done := make(chan struct{})
+	go func() {
+		h.active.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	log.Printf("Drain deadline exceeded, closing %d remaining connections", h.connMgr.Count())
+	h.connMgr.CloseAllConnections()
+	select {
+	case <-done:
+	case <-time.After(forceCloseGrace):
+		log.Println("Timed out waiting for connections to clean up")
+	}
+	return ctx.Err()
+}
+
+// reconnectReason 生成关闭帧的 reason，关闭帧的 reason 最长 123 字节
+func reconnectReason(maxDelay time.Duration) string {
+	hint := ReconnectHint{Reconnect: true}
+	if maxDelay > 0 {
+		hint.RetryAfterMs = rand.Int64N(maxDelay.Milliseconds() + 1)
+	}
+	data, _ := json.Marshal(hint)
+	return string(data)
+}
@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"time"
+
+	"github.com/focusandinsist/go-ws-srv/internal/connection"
+	"github.com/focusandinsist/go-ws-srv/protocol"
+
+	"github.com/google/uuid"
+)
+
+// enrich 为客户端发来的应用事件填写服务端字段，客户端自己填写了这些字段时拒绝整条消息
+// 发送者来自握手时的认证身份，防止冒充其他用户；会话 ID 和序号在保存前由 assignSeq 分配
+func enrich(client *connection.Client, msg *protocol.Message) error {
+	fields := map[string]string{}
+	if msg.ID != "" {
+		fields["id"] = "server_assigned"
+	}
+	if msg.SenderID != "" {
+		fields["sender_id"] = "server_assigned"
+	}
+	if msg.Timestamp != 0 {
+		fields["ts"] = "server_assigned"
+	}
+	if msg.ConvID != "" {
+		fields["conv_id"] = "server_assigned"
+	}
+	if msg.Seq != 0 {
+		fields["seq"] = "server_assigned"
+	}
+	if len(fields) > 0 {
+		err := protocol.NewError(protocol.CodeBadRequest, "fields assigned by the server must not be set")
+		err.Fields = fields
+		return err
+	}
+
+	stamp(msg, client.UserID)
+	return nil
+}
+
+// stamp 填写发送者，分配全局唯一的消息 ID 并记录服务端时间
+func stamp(msg *protocol.Message, senderID string) {
+	msg.ID = uuid.NewString()
+	msg.SenderID = senderID
+	msg.Timestamp = time.Now().UnixMilli()
+}
@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/focusandinsist/go-ws-srv/internal/connection"
+	"github.com/focusandinsist/go-ws-srv/protocol"
+)
+
+func TestEnrich(t *testing.T) {
+	client := connection.NewClient(nil, "alice", connection.Options{})
+	tests := []struct {
+		name       string
+		msg        protocol.Message
+		wantFields map[string]string // 为空表示消息应当通过
+	}{
+		{"clean", protocol.Message{Event: "chat", ReceiverID: "bob", Data: json.RawMessage(`{}`)}, nil},
+		{"id", protocol.Message{Event: "chat", ID: "forged"}, map[string]string{"id": "server_assigned"}},
+		{"sender_id", protocol.Message{Event: "chat", SenderID: "mallory"}, map[string]string{"sender_id": "server_assigned"}},
+		{"ts", protocol.Message{Event: "chat", Timestamp: 1}, map[string]string{"ts": "server_assigned"}},
+		{"conv_id", protocol.Message{Event: "chat", ConvID: "dm:/chat:alice:bob"}, map[string]string{"conv_id": "server_assigned"}},
+		{"seq", protocol.Message{Event: "chat", Seq: 7}, map[string]string{"seq": "server_assigned"}},
+		{
+			"all server fields",
+			protocol.Message{Event: "chat", ID: "forged", SenderID: "mallory", Timestamp: 1, ConvID: "c", Seq: -1},
+			map[string]string{
+				"id":        "server_assigned",
+				"sender_id": "server_assigned",
+				"ts":        "server_assigned",
+				"conv_id":   "server_assigned",
+				"seq":       "server_assigned",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := tt.msg
+			before := time.Now().UnixMilli()
+			err := enrich(client, &msg)
+
+			if tt.wantFields != nil {
+				var perr *protocol.Error
+				if !errors.As(err, &perr) || perr.Code != protocol.CodeBadRequest {
+					t.Fatalf("enrich error = %v, want %s", err, protocol.CodeBadRequest)
+				}
+				if !reflect.DeepEqual(perr.Fields, tt.wantFields) {
+					t.Fatalf("error fields = %v, want %v", perr.Fields, tt.wantFields)
+				}
+				// 被拒绝的消息保持原样
+				if !reflect.DeepEqual(msg, tt.msg) {
+					t.Fatalf("rejected message changed to %+v", msg)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if msg.ID == "" {
+				t.Error("ID was not assigned")
+			}
+			if msg.SenderID != client.UserID {
+				t.Errorf("SenderID = %q, want %q", msg.SenderID, client.UserID)
+			}
+			if msg.Timestamp < before || msg.Timestamp > time.Now().UnixMilli() {
+				t.Errorf("Timestamp = %d, want the time enrich ran", msg.Timestamp)
+			}
+			if msg.ConvID != "" || msg.Seq != 0 {
+				t.Errorf("conv_id = %q, seq = %d, want them left for assignSeq", msg.ConvID, msg.Seq)
+			}
+		})
+	}
+}
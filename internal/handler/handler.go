@@ -4,19 +4,22 @@
 package handler
 
 import (
+	"errors"
 	"log"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/focusandinsist/go-ws-srv/internal/auth"
 	"github.com/focusandinsist/go-ws-srv/internal/broker"
 	"github.com/focusandinsist/go-ws-srv/internal/connection"
+	"github.com/focusandinsist/go-ws-srv/internal/delivery"
 	"github.com/focusandinsist/go-ws-srv/internal/event"
 	"github.com/focusandinsist/go-ws-srv/internal/message"
-	"github.com/focusandinsist/go-ws-srv/internal/room"
+	"github.com/focusandinsist/go-ws-srv/internal/namespace"
+	"github.com/focusandinsist/go-ws-srv/internal/presence"
 	"github.com/focusandinsist/go-ws-srv/internal/storage"
 	"github.com/focusandinsist/go-ws-srv/protocol"
-
-	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
@@ -24,54 +27,115 @@ import (
 type Handler struct {
 	connMgr      *connection.ConnectionManager
 	msgMgr       *message.MessageManager
-	roomMgr      *room.RoomManager
 	authMgr      *auth.AuthManager
-	kafkaBroker  *broker.KafkaBroker
-	redisStorage *storage.RedisStorage
-	eventMgr     *event.EventManager
-	mongoStorage *storage.MongoStorage
+	broker       broker.Broker
+	offlineStore storage.OfflineStore
+	nsMgr        *namespace.Manager // 每个命名空间有独立的事件处理器和房间
+	delivery     *delivery.Tracker  // 请求了 ack 的消息的重发
+	historyStore storage.HistoryStore
+	nodeID       string             // 当前节点 ID，集群内唯一
+	presence     presence.Registry  // 集群内用户所在的节点
+	middlewares  []event.Middleware // 所有命名空间共用的事件中间件，启动前通过 Use 添加
+	eventTimeout time.Duration      // 单个事件处理器的最长执行时间
+
+	drainMu    sync.Mutex
+	draining   bool           // 停机中，不再接受新连接
+	drainDelay time.Duration  // 停机时客户端重连等待时间的上限，见 Drain
+	active     sync.WaitGroup // 尚未清理完成的连接
 }
 
 // NewHandler 创建 Handler 实例
-func NewHandler(connMgr *connection.ConnectionManager, msgMgr *message.MessageManager, authMgr *auth.AuthManager, roomMgr *room.RoomManager, kafkaBroker *broker.KafkaBroker, redisStorage *storage.RedisStorage, mongoStorage *storage.MongoStorage) *Handler {
-	eventMgr := event.NewEventManager()
+func NewHandler(connMgr *connection.ConnectionManager, msgMgr *message.MessageManager, authMgr *auth.AuthManager, msgBroker broker.Broker, offlineStore storage.OfflineStore, historyStore storage.HistoryStore, tracker *delivery.Tracker, nodeID string, registry presence.Registry) *Handler {
 	return &Handler{
 		connMgr:      connMgr,
 		msgMgr:       msgMgr,
-		roomMgr:      roomMgr,
 		authMgr:      authMgr,
-		kafkaBroker:  kafkaBroker,
-		redisStorage: redisStorage,
-		eventMgr:     eventMgr,
-		mongoStorage: mongoStorage,
+		broker:       msgBroker,
+		offlineStore: offlineStore,
+		nsMgr:        namespace.NewManager(),
+		delivery:     tracker,
+		historyStore: historyStore,
+		nodeID:       nodeID,
+		presence:     registry,
+		eventTimeout: defaultEventTimeout,
 	}
 }
 
-// RegisterEventHandler 注册事件处理器
-func (h *Handler) RegisterEventHandler(eventType string, handler func(*connection.Client, *protocol.Message)) {
-	h.eventMgr.Register(eventType, handler)
+// RegisterEventHandler 在默认命名空间注册事件处理器，mws 只作用于该事件
+func (h *Handler) RegisterEventHandler(eventType string, handler event.HandlerFunc, mws ...event.Middleware) {
+	h.nsMgr.Of(namespace.Default).On(eventType, handler, mws...)
+}
+
+// Of 获取命名空间，不存在时创建，用于注册命名空间内的事件处理器、中间件和授权
+func (h *Handler) Of(name string) *namespace.Namespace {
+	return h.nsMgr.Of(name)
 }
 
-// HandleMessage 处理客户端发送的消息
+// HandleMessage 处理客户端发送的一帧，所有入站消息都从这里进入
+// 控制帧（ack、ping、命名空间、房间、sync）由服务端直接处理；应用事件经过中间件后交给事件处理器校验并投递，处理成功后保存
 func (h *Handler) HandleMessage(client *connection.Client, data []byte) {
-	msg, err := protocol.Decode(data)
+	msg, err := client.Codec.Unmarshal(data)
 	if err != nil {
 		log.Println("解析消息失败:", err)
+		var perr *protocol.Error
+		if !errors.As(err, &perr) {
+			perr = protocol.NewError(protocol.CodeBadRequest, "invalid message: %v", err)
+		}
+		h.replyError(client, &protocol.Message{}, perr)
+		return
+	}
+	msg.Namespace = namespace.Normalize(msg.Namespace)
+
+	// 控制帧由服务端直接处理，不保存也不转发
+	if isControlEvent(msg.Event) {
+		h.handleControl(client, msg)
+		return
+	}
+
+	// 应用事件只处理已加入的命名空间内的
+	ns, err := h.joinedNamespace(client, msg)
+	if err != nil {
+		h.replyError(client, msg, err)
 		return
 	}
 
-	// 存储消息到 MongoDB
-	h.mongoStorage.StoreMessage(msg)
+	// 发送者、消息 ID 和时间由服务端填写，之后保存、转发和投递的都是这个信封
+	if err := enrich(client, msg); err != nil {
+		h.replyError(client, msg, err)
+		return
+	}
+
+	h.dispatch(ns, client, msg)
+}
 
-	// 将消息发送到 Kafka
-	h.kafkaBroker.SendMessage(string(msg.Data))
+// prepareDirect 为单聊消息分配会话序号，由 SendDirectMessage 在校验通过之后、投递之前调用
+// 会话只由发送者和接收者决定，客户端带上的 room 会被清除；分配失败时返回错误，消息不投递
+func (h *Handler) prepareDirect(client *connection.Client, msg *protocol.Message) error {
+	msg.Room = ""
+	if err := h.assignSeq(msg, protocol.ConversationID(msg.Namespace, "", client.UserID, msg.ReceiverID)); err != nil {
+		return err
+	}
 
-	// 如果接收者不在线，存储到 Redis
-	if h.connMgr.GetClient(msg.ReceiverID) == nil {
-		h.redisStorage.AddOfflineMessage(msg.ReceiverID, string(msg.Data))
+	// 如果接收者在整个集群都不在线，存入离线队列；在线但不在消息的命名空间内时由 directLocal 存入
+	if !h.isOnline(msg.ReceiverID) {
+		h.storeOffline(msg.ReceiverID, msg)
 	}
+	return nil
+}
 
-	h.eventMgr.Trigger(msg.Event, client, msg)
+// prepareRoom 为房间消息分配会话序号，由 RoomMessage 在确认发送者是房间成员之后调用
+// 房间消息不进入离线队列，成员通过 sync 补齐；客户端带上的 receiver_id 会被清除
+func (h *Handler) prepareRoom(msg *protocol.Message) error {
+	msg.ReceiverID = ""
+	return h.assignSeq(msg, protocol.ConversationID(msg.Namespace, msg.Room, "", ""))
+}
+
+// persist 在事件处理器返回 nil 之后保存消息，适用于所有应用事件
+// 单聊和房间消息已由处理器分配会话序号，可以通过 sync 查询；其他事件只作为历史记录保存
+func (h *Handler) persist(msg *protocol.Message) {
+	if err := h.historyStore.StoreMessage(msg); err != nil {
+		log.Printf("保存消息失败: %v", err)
+	}
 }
 
 // HandleWebSocket 处理 WebSocket 请求
@@ -79,14 +143,45 @@ func (h *Handler) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 	// 这里是 WebSocket 处理的逻辑
 	log.Println("Handling WebSocket connection...")
 
-	// 示例：如果是 WebSocket 连接，升级协议并处理连接
-	// 可以在这里进行身份验证，连接管理等操作
+	// 停机过程中拒绝新连接，客户端应连接其他节点
+	if !h.beginConn() {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	started := false
+	defer func() {
+		if !started {
+			h.active.Done()
+		}
+	}()
+
+	// 升级协议前先完成身份验证，失败时返回 401/403
+	principal, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	// 握手时可以通过 ?namespace= 直接加入一个命名空间，默认为 /
+	ns := h.nsMgr.Get(r.URL.Query().Get("namespace"))
+	if ns == nil {
+		http.Error(w, namespace.ErrUnknownNamespace.Error(), http.StatusNotFound)
+		return
+	}
+	if err := ns.CheckPrincipal(principal); err != nil {
+		log.Printf("Rejecting user %s for namespace %s: %v", principal.UserID, ns.Name, err)
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
 
 	// 使用 gorilla/websocket 库来升级连接
 	upgrader := websocket.Upgrader{
 		CheckOrigin: func(r *http.Request) bool {
 			return true
 		},
+		// 客户端通过子协议选择消息格式，同时提供多个时优先使用 Protobuf；
+		// 只通过子协议传 token 时回显 access_token，否则浏览器会断开连接
+		Subprotocols: []string{protocol.SubprotocolProtobuf, protocol.SubprotocolJSON, tokenSubprotocol},
 	}
 
 	conn, err := upgrader.Upgrade(w, r, nil)
@@ -95,74 +190,141 @@ func (h *Handler) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 从 URL 查询参数中获取 userID 和 reconnect 标记
-	// userID := r.URL.Query().Get("user_id")
+	// 从 URL 查询参数中获取 reconnect 标记
 	reconnect := r.URL.Query().Get("reconnect") // "true" 表示重连
 
-	newClient := &connection.Client{
-		Conn:   conn,
-		UserID: "test", // get userID from http head
+	newClient := h.connMgr.NewClient(conn, principal.UserID)
+	newClient.Principal = principal
+	if codec, ok := protocol.CodecFor(conn.Subprotocol()); ok {
+		newClient.Codec = codec
+	}
+	newClient.DeviceID = principal.DeviceID
+	if deviceID := r.URL.Query().Get("device_id"); deviceID != "" {
+		newClient.DeviceID = deviceID
 	}
 
 	// 在连接管理器中注册新的连接
-	h.connMgr.AddClient(newClient)
-	log.Println("WebSocket connection established")
+	if err := h.connMgr.AddClient(newClient); err != nil {
+		log.Printf("Rejecting connection of user %s: %v", newClient.UserID, err)
+		newClient.Close(websocket.ClosePolicyViolation, err.Error())
+		return
+	}
+	log.Printf("WebSocket connection established: user=%s conn=%s", newClient.UserID, newClient.ID)
+	h.closeIfDraining(newClient)
+	h.registerPresence(newClient)
+
+	if err := ns.Connect(newClient); err != nil {
+		log.Printf("Rejecting connection of user %s to namespace %s: %v", newClient.UserID, ns.Name, err)
+		h.connMgr.RemoveClient(newClient)
+		h.unregisterPresence(newClient)
+		newClient.Close(websocket.ClosePolicyViolation, err.Error())
+		return
+	}
+
+	// 启动写协程和心跳检测
+	go newClient.WritePump()
+	go newClient.StartHeartbeat()
 
 	// 如果是断线重连，则恢复之前状态
 	if reconnect == "true" {
 		h.RestoreClientState(newClient)
 	}
 
-	// 启动心跳检测
-	go newClient.StartHeartbeat()
-
-	// **启动 ReadPump，让它监听消息**
-	go h.ReadPump(newClient)
+	// **启动 ReadPump，让它监听消息**，ReadPump 返回时连接清理完成
+	started = true
+	go func() {
+		defer h.active.Done()
+		h.ReadPump(newClient)
+	}()
 }
 
 // 新增 ReadPump，让它监听 WebSocket 消息，并调用 HandleMessage
 func (h *Handler) ReadPump(client *connection.Client) {
 	defer func() {
 		h.connMgr.RemoveClient(client)
-		client.Conn.Close()
+		h.unregisterPresence(client)
+		h.nsMgr.DisconnectAll(client)
+		client.Close(websocket.CloseNormalClosure, "")
+		// 未确认的消息转入离线队列，重连后补发
+		for _, m := range h.delivery.Drain(client.ID) {
+			h.storeOffline(client.UserID, m)
+		}
 	}()
 
 	for {
-		_, msg, err := client.Conn.ReadMessage()
+		msgType, msg, err := client.Conn.ReadMessage()
 		if err != nil {
 			log.Println("Error reading message:", err)
 			break
 		}
+		// 帧类型必须与握手时协商的格式一致
+		if (msgType == websocket.BinaryMessage) != client.Codec.Binary() {
+			log.Printf("用户 %s 发送了与 %s 不符的帧类型 %d，已忽略", client.UserID, client.Codec.Name(), msgType)
+			continue
+		}
 
 		// 收到消息后调用 HandleMessage
 		h.HandleMessage(client, msg)
 	}
 }
 
-// Handler 中负责转发的部分：使用 ConnectionManager 来获取目标连接，然后发送消息
-func (h *Handler) BroadcastMessage(client *connection.Client, msg *protocol.Message) {
-	// 获取所有连接（这部分由 ConnectionManager 提供接口）
-	for _, client := range h.connMgr.GetAllClients() {
-		err := client.Conn.WriteMessage(websocket.TextMessage, []byte(msg.Data))
-		if err != nil {
+// Handler 中负责转发的部分：先投递给本节点的连接，再通过 broker 转发给其他节点
+// 广播不经过可靠投递，发出的副本去掉发送者的 ack 请求，发送者请求了 ack 时回复消息 ID
+func (h *Handler) BroadcastMessage(client *connection.Client, msg *protocol.Message) error {
+	out := *msg
+	out.Ack, out.AckID = false, ""
+	h.broadcastLocal(&out)
+	h.publish(fanoutBroadcast, &out)
+	ackSent(client, msg)
+	return nil
+}
+
+// broadcastLocal 广播给本节点同一命名空间内的连接
+// SendMessage 只是入队，慢连接不会拖住整个广播。发送完整的消息，接收方据此得到发送者并按消息 ID 去重
+func (h *Handler) broadcastLocal(msg *protocol.Message) {
+	frames := newEncodedFrames(msg)
+	h.nsMgr.Of(msg.Namespace).ForEach(func(client *connection.Client) {
+		if err := frames.send(client); err != nil {
 			log.Printf("发送消息给用户 %s 失败: %v", client.UserID, err)
 		}
+	})
+}
+
+func (h *Handler) SendDirectMessage(client *connection.Client, msg *protocol.Message) error {
+	if msg.ReceiverID == "" {
+		return protocol.NewError(protocol.CodeBadRequest, "missing receiver_id")
+	}
+	if err := h.prepareDirect(client, msg); err != nil {
+		return err
 	}
+	h.directLocal(msg)
+	h.routeDirect(msg)
+	ackSent(client, msg)
+	return nil
 }
 
-func (h *Handler) SendDirectMessage(client *connection.Client, msg *protocol.Message) {
-	// 例如，假设 msg 中的 Data 或者另有字段指定接收者 ID
-	target := h.connMgr.GetClient(msg.ReceiverID)
-	if target == nil {
-		log.Printf("找不到目标用户: %s", msg.ReceiverID)
+// directLocal 发送给接收者在本节点、同一命名空间内的所有设备，发送完整的消息以便客户端拿到会话序号
+// 接收者在本节点有连接、但都不在消息的命名空间内时存入离线队列：prepareDirect 只看用户是否在线，
+// 不知道连接属于哪个命名空间。接收者在多个节点上都是这种情况时会存入多份，客户端按消息 ID 去重
+func (h *Handler) directLocal(msg *protocol.Message) {
+	targets := h.connMgr.GetUserClients(msg.ReceiverID)
+	if len(targets) == 0 {
 		return
 	}
-
-	// 这两种写法都可以，回家取舍一下
-	// err := target.Conn.WriteMessage(websocket.TextMessage, []byte(msg.Data))
-	err := target.SendMessage(websocket.TextMessage, []byte(msg.Data))
-	if err != nil {
-		log.Printf("发送消息给用户 %s 失败: %v", target.UserID, err)
+	frames := newEncodedFrames(msg)
+	ns := h.nsMgr.Of(msg.Namespace)
+	inNamespace := false
+	for _, target := range targets {
+		if !ns.Has(target.ID) {
+			continue
+		}
+		inNamespace = true
+		if err := h.deliver(target, frames); err != nil {
+			log.Printf("发送消息给用户 %s 失败: %v", msg.ReceiverID, err)
+		}
+	}
+	if !inNamespace {
+		h.storeOffline(msg.ReceiverID, msg)
 	}
 }
 
@@ -180,59 +342,32 @@ func (h *Handler) RestoreClientState(client *connection.Client) {
 	//     client.Conn.WriteMessage(websocket.TextMessage, m.Data)
 	// }
 
-	offlineMessages, err := h.redisStorage.GetOfflineMessages(client.UserID)
+	offlineMessages, err := h.offlineStore.GetOfflineMessages(client.UserID)
 	if err != nil {
 		log.Printf("Error getting offline messages for client %s: %v", client.UserID, err)
 		return
 	}
 
-	for _, msg := range offlineMessages {
-		client.SendMessage(websocket.TextMessage, []byte(msg))
-	}
-
-	h.redisStorage.ClearOfflineMessages(client.UserID)
-}
-
-// 系统3的代码，似乎有点问题？
-func (h *Handler) OnMessage(c *connection.Client, rawData []byte) {
-	msg, err := protocol.Decode(rawData)
-	if err != nil {
-		log.Println("协议解析失败:", err)
-		return
-	}
-
-	// ack
-	if msg.Event == "__ack__" && msg.AckID != "" {
-		protocol.AckManager.Receive(msg.AckID)
-		return
-	}
-
-	switch msg.Event {
-	case "chat":
-		// 正常业务逻辑
-	}
-}
-
-// 系统3的代码，似乎有点问题？
-func (h *Handler) SendDirectMessage2(client *connection.Client, msg *protocol.Message) {
-	data := map[string]any{
-		"user": "tom",
-		"text": "hello",
-	}
-
-	// 生成 ackID 并发送消息
-	ackID := uuid.NewString()
-	msgByte, _ := protocol.Encode("chat", data, false, ackID)
-
-	// 注册 ack 等待
-	go func() {
-		_, err := protocol.AckManager.Wait()
+	for _, raw := range offlineMessages {
+		msg, err := protocol.Decode([]byte(raw))
 		if err != nil {
-			log.Printf("等待 ack 超时: %v", err)
-		} else {
-			log.Println("收到 ack 确认")
+			// 早期版本只保存了 Data，原样发给 JSON 连接
+			if !client.Codec.Binary() {
+				client.SendMessage(websocket.TextMessage, []byte(raw))
+			}
+			continue
 		}
-	}()
+		// 可靠投递的消息补发时重新进入待确认列表
+		if msg.Ack && msg.ID != "" {
+			h.delivery.Send(client, msg)
+			continue
+		}
+		client.SendEnvelope(msg)
+	}
 
-	client.SendMessage(websocket.TextMessage, msgByte)
+	// 只删除已经补发的部分，补发期间新进入队列的消息保留到下次；
+	// 如果在这之前崩溃，消息会被重复补发，客户端按消息 ID / 会话序号去重，缺失的部分可以通过 sync 补齐
+	if err := h.offlineStore.TrimOfflineMessages(client.UserID, len(offlineMessages)); err != nil {
+		log.Printf("Error trimming offline messages for client %s: %v", client.UserID, err)
+	}
 }
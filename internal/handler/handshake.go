@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/focusandinsist/go-ws-srv/internal/auth"
+)
+
+// tokenSubprotocol 浏览器无法自定义握手头，可以通过 Sec-WebSocket-Protocol: access_token, <token> 传递 token
+const tokenSubprotocol = "access_token"
+
+// tokenFromRequest 依次从 Authorization 头、Sec-WebSocket-Protocol、?token= 中取出 token
+func tokenFromRequest(r *http.Request) string {
+	if h := r.Header.Get("Authorization"); h != "" {
+		if token, ok := strings.CutPrefix(h, "Bearer "); ok {
+			return strings.TrimSpace(token)
+		}
+	}
+
+	protocols := websocketSubprotocols(r)
+	for i, p := range protocols {
+		if p == tokenSubprotocol && i+1 < len(protocols) {
+			return protocols[i+1]
+		}
+	}
+
+	return r.URL.Query().Get("token")
+}
+
+// websocketSubprotocols 解析 Sec-WebSocket-Protocol 头
+func websocketSubprotocols(r *http.Request) []string {
+	var protocols []string
+	for _, h := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, p := range strings.Split(h, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				protocols = append(protocols, p)
+			}
+		}
+	}
+	return protocols
+}
+
+// authenticate 校验握手请求，失败时直接写回 401/403，此时尚未升级协议
+func (h *Handler) authenticate(w http.ResponseWriter, r *http.Request) (*auth.Principal, bool) {
+	principal, err := h.authMgr.Authenticate(tokenFromRequest(r))
+	if err == nil {
+		return principal, true
+	}
+
+	log.Printf("Rejecting WebSocket handshake from %s: %v", r.RemoteAddr, err)
+	if errors.Is(err, auth.ErrForbidden) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	} else {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="ws"`)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	}
+	return nil, false
+}
@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"errors"
+	"log"
+
+	"github.com/focusandinsist/go-ws-srv/internal/connection"
+	"github.com/focusandinsist/go-ws-srv/internal/event"
+	"github.com/focusandinsist/go-ws-srv/internal/namespace"
+	"github.com/focusandinsist/go-ws-srv/protocol"
+)
+
+// Use 添加所有命名空间共用的事件中间件，最先执行，需要在开始接受连接前调用
+// 执行顺序：全局中间件 → 命名空间中间件 → 事件中间件 → 事件处理器 → 保存消息
+func (h *Handler) Use(mws ...event.Middleware) *Handler {
+	h.middlewares = append(h.middlewares, mws...)
+	return h
+}
+
+// dispatch 经过中间件链调用事件处理器，处理失败或事件未注册时向客户端回复错误
+// 处理器返回 nil 后保存消息，中间件中断或校验失败的消息不会被保存
+func (h *Handler) dispatch(ns *namespace.Namespace, client *connection.Client, msg *protocol.Message) {
+	handler, nsMws, ok := ns.Events().Lookup(msg.Event)
+	if !ok {
+		h.replyError(client, msg, protocol.NewError(protocol.CodeUnknownEvent, "unknown event %q in namespace %s", msg.Event, ns.Name))
+		return
+	}
+
+	mws := make([]event.Middleware, 0, len(h.middlewares)+len(nsMws))
+	mws = append(mws, h.middlewares...)
+	mws = append(mws, nsMws...)
+	if err := event.Chain(handler, mws...)(client, msg); err != nil {
+		h.replyError(client, msg, err)
+		return
+	}
+	h.persist(msg)
+}
+
+// replyError 向客户端回复结构化错误：请求了 ack 时作为 ack 的内容，否则发送 error 事件
+func (h *Handler) replyError(client *connection.Client, req *protocol.Message, err error) {
+	perr := toProtocolError(err)
+	if perr.Code == protocol.CodeInternal && !errors.Is(err, event.ErrInternal) {
+		// 具体原因只记录在日志里，可能包含存储、broker 等内部信息
+		log.Printf("事件 %s 处理失败 (user=%s conn=%s): %v", req.Event, client.UserID, client.ID, err)
+	}
+	perr.Event = req.Event
+
+	var sendErr error
+	if req.Ack && req.AckID != "" {
+		sendErr = client.Ack(req, map[string]any{"error": perr})
+	} else {
+		var msg *protocol.Message
+		if msg, sendErr = protocol.NewMessage(protocol.EventError, perr); sendErr == nil {
+			msg.Namespace = req.Namespace
+			sendErr = client.SendEnvelope(msg)
+		}
+	}
+	if sendErr != nil {
+		log.Printf("回复用户 %s 的错误失败: %v", client.UserID, sendErr)
+	}
+}
+
+// toProtocolError 把处理器返回的错误转换为错误码，返回副本以免修改处理器共用的错误值
+// 未知的错误一律作为 internal 回复，不向客户端暴露原始错误信息
+func toProtocolError(err error) *protocol.Error {
+	var perr *protocol.Error
+	switch {
+	case errors.As(err, &perr):
+		cp := *perr
+		return &cp
+	case errors.Is(err, namespace.ErrUnknownNamespace), errors.Is(err, namespace.ErrNotConnected):
+		return protocol.NewError(protocol.CodeBadRequest, "%v", err)
+	default:
+		return protocol.NewError(protocol.CodeInternal, "internal error")
+	}
+}
@@ -0,0 +1,42 @@
+package handler
+
+import (
+	"log"
+
+	"github.com/focusandinsist/go-ws-srv/internal/connection"
+	"github.com/focusandinsist/go-ws-srv/internal/namespace"
+	"github.com/focusandinsist/go-ws-srv/protocol"
+)
+
+// 命名空间相关的内置事件
+const (
+	EventConnect      = "connect"       // 加入 msg.Namespace，成功时服务端回复同名事件
+	EventDisconnect   = "disconnect"    // 离开 msg.Namespace
+	EventConnectError = "connect_error" // 加入命名空间失败
+)
+
+// connectNamespace 处理客户端发来的 connect 包
+func (h *Handler) connectNamespace(client *connection.Client, name string) {
+	ns := h.nsMgr.Get(name)
+	err := namespace.ErrUnknownNamespace
+	if ns != nil {
+		err = ns.Connect(client)
+	}
+	if err != nil {
+		log.Printf("用户 %s 加入命名空间 %s 失败: %v", client.UserID, name, err)
+		h.sendNamespaceEvent(client, EventConnectError, name, map[string]string{"message": err.Error()})
+		return
+	}
+	h.sendNamespaceEvent(client, EventConnect, name, nil)
+}
+
+// sendNamespaceEvent 向客户端发送命名空间相关的事件
+func (h *Handler) sendNamespaceEvent(client *connection.Client, event, name string, data any) {
+	msg, err := protocol.NewMessage(event, data)
+	if err != nil {
+		log.Printf("编码 %s 事件失败: %v", event, err)
+		return
+	}
+	msg.Namespace = name
+	client.SendEnvelope(msg)
+}
@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"log"
+
+	"github.com/focusandinsist/go-ws-srv/internal/connection"
+	"github.com/focusandinsist/go-ws-srv/protocol"
+)
+
+// 房间相关的内置事件
+const (
+	EventJoin  = "join"  // 加入 msg.Room，控制帧
+	EventLeave = "leave" // 退出 msg.Room，控制帧
+	EventRoom  = "room"  // 向 msg.Room 的成员发送消息
+)
+
+var errMissingRoom = protocol.NewError(protocol.CodeBadRequest, "missing room")
+
+// JoinRoom 将连接加入房间
+func (h *Handler) JoinRoom(client *connection.Client, msg *protocol.Message) error {
+	if msg.Room == "" {
+		return errMissingRoom
+	}
+	rooms := h.nsMgr.Of(msg.Namespace).Rooms()
+	rooms.Join(msg.Room, client.ID)
+	log.Printf("用户 %s (conn %s) 加入房间 %s", client.UserID, client.ID, msg.Room)
+	return client.Ack(msg, map[string]any{"room": msg.Room, "members": len(rooms.Members(msg.Room))})
+}
+
+// LeaveRoom 将连接退出房间
+func (h *Handler) LeaveRoom(client *connection.Client, msg *protocol.Message) error {
+	if msg.Room == "" {
+		return errMissingRoom
+	}
+	h.nsMgr.Of(msg.Namespace).Rooms().Leave(msg.Room, client.ID)
+	log.Printf("用户 %s (conn %s) 退出房间 %s", client.UserID, client.ID, msg.Room)
+	return client.Ack(msg, map[string]any{"room": msg.Room})
+}
+
+// RoomMessage 向房间内除发送者外的所有成员发送消息，只有房间成员可以发送，发送者请求了 ack 时回复消息 ID
+// 请求了 ack 的消息对每个成员可靠投递，重发时使用服务端的 AckID
+// 房间属于命名空间，不同命名空间的同名房间互不影响
+func (h *Handler) RoomMessage(client *connection.Client, msg *protocol.Message) error {
+	if msg.Room == "" {
+		return errMissingRoom
+	}
+	ns := h.nsMgr.Of(msg.Namespace)
+	if !ns.Rooms().IsMember(msg.Room, client.ID) {
+		return protocol.NewError(protocol.CodeForbidden, "not a member of room %q", msg.Room)
+	}
+	if err := h.prepareRoom(msg); err != nil {
+		return err
+	}
+
+	h.roomLocal(msg, client.ID)
+	h.publish(fanoutRoom, msg)
+	ackSent(client, msg)
+	return nil
+}
+
+// roomLocal 发给房间在本节点的成员，exceptConn 为发送者的连接 ID
+func (h *Handler) roomLocal(msg *protocol.Message, exceptConn string) {
+	ns := h.nsMgr.Of(msg.Namespace)
+	members := ns.Rooms().Members(msg.Room)
+	if len(members) == 0 {
+		return
+	}
+	frames := newEncodedFrames(msg)
+	for _, connID := range members {
+		if connID == exceptConn {
+			continue
+		}
+		target := ns.Client(connID)
+		if target == nil {
+			continue
+		}
+		if err := h.deliver(target, frames); err != nil {
+			log.Printf("发送房间消息给用户 %s 失败: %v", target.UserID, err)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/focusandinsist/go-ws-srv/internal/connection"
+	"github.com/focusandinsist/go-ws-srv/protocol"
+)
+
+// EventSync 客户端上报每个会话最后收到的序号，服务端回复缺失的消息
+const EventSync = "sync"
+
+// syncLimit 每个会话一次最多返回的消息数，超过时 has_more 为 true，客户端用新的 last_seq 再次同步
+const syncLimit = 200
+
+// SyncRequest sync 事件的 Data
+type SyncRequest struct {
+	Conversations []SyncCursor `json:"conversations"`
+}
+
+// SyncCursor 单个会话的同步位置，Peer（单聊对方）和 Room 二选一
+type SyncCursor struct {
+	Peer    string `json:"peer,omitempty"`
+	Room    string `json:"room,omitempty"`
+	LastSeq int64  `json:"last_seq"`
+}
+
+// SyncResult 单个会话缺失的消息
+type SyncResult struct {
+	ConvID   string              `json:"conv_id"`
+	Messages []*protocol.Message `json:"messages"`
+	HasMore  bool                `json:"has_more"`
+}
+
+// assignSeq 在会话 convID 内为消息分配序号，失败时消息不能投递，否则无法通过 sync 补齐
+func (h *Handler) assignSeq(msg *protocol.Message, convID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	seq, err := h.historyStore.NextSeq(ctx, convID)
+	if err != nil {
+		return fmt.Errorf("assign seq in conversation %s: %w", convID, err)
+	}
+	msg.ConvID = convID
+	msg.Seq = seq
+	return nil
+}
+
+// Sync 处理 sync 事件：按客户端上报的 last_seq 从历史消息中取出每个会话缺失的部分
+// 会话 ID 由服务端根据当前用户计算，单聊只能同步自己参与的会话，房间只能同步已加入的房间
+func (h *Handler) Sync(client *connection.Client, msg *protocol.Message) error {
+	var req SyncRequest
+	if err := json.Unmarshal(msg.Data, &req); err != nil {
+		return protocol.NewError(protocol.CodeBadRequest, "invalid sync request: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rooms := h.nsMgr.Of(msg.Namespace).Rooms()
+	results := make([]SyncResult, 0, len(req.Conversations))
+	for _, cur := range req.Conversations {
+		if cur.Room != "" && !rooms.IsMember(cur.Room, client.ID) {
+			log.Printf("用户 %s 不在房间 %s 中，跳过同步", client.UserID, cur.Room)
+			continue
+		}
+		convID := protocol.ConversationID(msg.Namespace, cur.Room, client.UserID, cur.Peer)
+		if convID == "" {
+			continue
+		}
+
+		// 多取一条用于判断是否还有更多
+		messages, err := h.historyStore.GetConversation(ctx, convID, cur.LastSeq, syncLimit+1)
+		if err != nil {
+			log.Printf("查询会话 %s 的历史消息失败: %v", convID, err)
+			continue
+		}
+		// 历史消息里的 ack 字段属于原发送方的请求，补发时去掉
+		for _, m := range messages {
+			m.Ack, m.AckID = false, ""
+		}
+		result := SyncResult{ConvID: convID, Messages: messages}
+		if len(messages) > syncLimit {
+			result.Messages = messages[:syncLimit]
+			result.HasMore = true
+		}
+		results = append(results, result)
+	}
+
+	resp := map[string]any{"conversations": results}
+	if msg.Ack {
+		return client.Ack(msg, resp)
+	}
+	reply, err := protocol.NewMessage(EventSync, resp)
+	if err != nil {
+		return err
+	}
+	reply.Namespace = msg.Namespace
+	return client.SendEnvelope(reply)
+}
@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"github.com/focusandinsist/go-ws-srv/internal/connection"
+	"github.com/focusandinsist/go-ws-srv/internal/event"
+	"github.com/focusandinsist/go-ws-srv/internal/namespace"
+	"github.com/focusandinsist/go-ws-srv/protocol"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// TypedHandler 带类型的事件处理器，req 由 Data 解码得到；请求了 ack 时 resp 作为 ack 的内容回复
+type TypedHandler[T any] func(ctx *Context, req T) (resp any, err error)
+
+// validate 按 validate 标签校验请求，错误中的字段名使用 json 名称
+var validate = newValidator()
+
+func newValidator() *validator.Validate {
+	v := validator.New(validator.WithRequiredStructEnabled())
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		if name == "" {
+			return f.Name
+		}
+		return name
+	})
+	return v
+}
+
+// On 在默认命名空间注册带类型的事件处理器
+func On[T any](h *Handler, eventType string, fn TypedHandler[T], mws ...event.Middleware) {
+	OnNamespace(h, namespace.Default, eventType, fn, mws...)
+}
+
+// OnNamespace 在指定命名空间注册带类型的事件处理器
+// Data 解码失败返回 bad_request，校验失败返回 validation_failed，处理器的错误原样回复给客户端
+func OnNamespace[T any](h *Handler, ns, eventType string, fn TypedHandler[T], mws ...event.Middleware) {
+	h.Of(ns).On(eventType, func(client *connection.Client, msg *protocol.Message) error {
+		var req T
+		if err := decodeRequest(msg.Data, &req); err != nil {
+			return err
+		}
+		ctx, cancel := h.newContext(client, msg)
+		defer cancel()
+		resp, err := fn(ctx, req)
+		if err != nil {
+			return err
+		}
+		return ctx.Ack(resp)
+	}, mws...)
+}
+
+// decodeRequest 把 Data 解码到 req 并校验，Data 为空时 req 保持零值，同样需要通过校验
+func decodeRequest(data json.RawMessage, req any) error {
+	if len(bytes.TrimSpace(data)) > 0 {
+		if err := json.Unmarshal(data, req); err != nil {
+			return protocol.NewError(protocol.CodeBadRequest, "invalid data: %v", err)
+		}
+	}
+
+	v := reflect.ValueOf(req).Elem()
+	for v.Kind() == reflect.Pointer && !v.IsNil() {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	err := validate.Struct(v.Interface())
+	if err == nil {
+		return nil
+	}
+	verrs, ok := err.(validator.ValidationErrors)
+	if !ok {
+		return protocol.NewError(protocol.CodeBadRequest, "invalid data: %v", err)
+	}
+	perr := protocol.NewError(protocol.CodeValidation, "invalid data")
+	perr.Fields = make(map[string]string, len(verrs))
+	for _, fe := range verrs {
+		// 去掉最外层的类型名，保留嵌套字段路径
+		_, field, _ := strings.Cut(fe.Namespace(), ".")
+		perr.Fields[field] = fe.Tag()
+	}
+	return perr
+}
@@ -1,6 +1,14 @@
+// HTTP 路由
+// 职责：WebSocket 入口、REST API、健康检查和监控指标挂在同一个 Gin 路由上，共用一个监听地址。
+// 每组路由的中间件可以通过配置指定。
 package httpapi
 
 import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
 	"net/http"
 
 	"github.com/focusandinsist/go-ws-srv/internal/connection"
@@ -8,32 +16,134 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func RunHTTPServer(connMgr *connection.ConnectionManager) {
-	r := gin.Default()
+// 路由分组，配置中按分组指定中间件
+const (
+	GroupWS      = "ws"      // /ws
+	GroupAPI     = "api"     // /online、/send
+	GroupHealth  = "health"  // /healthz、/readyz
+	GroupMetrics = "metrics" // /metrics
+)
 
-	r.GET("/online", func(c *gin.Context) {
-		users := connMgr.GetAllUserIDs()
-		c.JSON(http.StatusOK, gin.H{"online_users": users})
+// middlewares 可以在配置中使用的中间件
+func middlewares(opts Options) map[string]func() gin.HandlerFunc {
+	return map[string]func() gin.HandlerFunc{
+		"logger":   gin.Logger,
+		"recovery": gin.Recovery,
+		"mtls":     func() gin.HandlerFunc { return requireClientCert(opts.ServiceIdentities) },
+	}
+}
+
+// DefaultMiddleware 各分组默认的中间件
+func DefaultMiddleware() map[string][]string {
+	return map[string][]string{
+		GroupWS:      {"recovery"},
+		GroupAPI:     {"recovery", "logger"},
+		GroupHealth:  {},
+		GroupMetrics: {"recovery"},
+	}
+}
+
+// Options 路由的依赖和配置
+type Options struct {
+	OnlineUsers func(ctx context.Context) ([]string, error) // 集群内的在线用户
+	// Push 把原始消息推送给集群内任意节点上的用户，deviceID 为空时推送给所有设备；
+	// 用户不在线时返回 connection.ErrUserNotFound
+	Push       func(ctx context.Context, userID, deviceID string, data []byte) error
+	WebSocket  http.HandlerFunc    // WebSocket 升级入口
+	Ready      func() error        // 就绪检查，返回错误时 /readyz 返回 503
+	Middleware map[string][]string // 分组 -> 中间件名，未指定的分组使用默认值
+	// ServiceIdentities 客户端证书 CN -> 服务身份，mtls 中间件使用
+	ServiceIdentities map[string]string
+}
+
+// NewRouter 创建路由，中间件配置不合法时返回错误
+func NewRouter(opts Options) (*gin.Engine, error) {
+	chains := DefaultMiddleware()
+	for group, names := range opts.Middleware {
+		if _, ok := chains[group]; !ok {
+			return nil, fmt.Errorf("httpapi: unknown route group %q", group)
+		}
+		chains[group] = names
+	}
+	available := middlewares(opts)
+	handlers := make(map[string][]gin.HandlerFunc, len(chains))
+	for group, names := range chains {
+		for _, name := range names {
+			if name == "mtls" && len(opts.ServiceIdentities) == 0 {
+				return nil, fmt.Errorf("httpapi: mtls middleware for group %q requires service identities", group)
+			}
+			mw, ok := available[name]
+			if !ok {
+				return nil, fmt.Errorf("httpapi: unknown middleware %q for group %q", name, group)
+			}
+			handlers[group] = append(handlers[group], mw())
+		}
+	}
+
+	r := gin.New()
+
+	ws := r.Group("/", handlers[GroupWS]...)
+	ws.GET("/ws", gin.WrapF(opts.WebSocket))
+
+	api := r.Group("/", handlers[GroupAPI]...)
+	api.GET("/online", onlineUsers(opts.OnlineUsers))
+	api.POST("/send", sendMessage(opts.Push))
+
+	health := r.Group("/", handlers[GroupHealth]...)
+	health.GET("/healthz", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
 	})
+	health.GET("/readyz", func(c *gin.Context) {
+		if opts.Ready != nil {
+			if err := opts.Ready(); err != nil {
+				c.JSON(http.StatusServiceUnavailable, gin.H{"status": "not ready", "error": err.Error()})
+				return
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ready"})
+	})
+
+	metrics := r.Group("/", handlers[GroupMetrics]...)
+	metrics.GET("/metrics", gin.WrapH(expvar.Handler()))
+
+	return r, nil
+}
+
+func onlineUsers(online func(ctx context.Context) ([]string, error)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		users, err := online(c.Request.Context())
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"online_users": users})
+	}
+}
 
-	r.POST("/send", func(c *gin.Context) {
+func sendMessage(push func(ctx context.Context, userID, deviceID string, data []byte) error) gin.HandlerFunc {
+	return func(c *gin.Context) {
 		var req struct {
-			UserID  string `json:"user_id"`
-			Message string `json:"message"`
+			UserID   string `json:"user_id"`
+			DeviceID string `json:"device_id"` // 可选，为空时发送给用户的所有设备
+			Message  string `json:"message"`
 		}
 		if err := c.ShouldBindJSON(&req); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		err := connMgr.SendMessageToUser(req.UserID, []byte(req.Message))
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		if err := push(c.Request.Context(), req.UserID, req.DeviceID, []byte(req.Message)); err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, connection.ErrUserNotFound) {
+				status = http.StatusNotFound
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
 			return
 		}
 
+		if service, ok := ServiceIdentity(c); ok {
+			log.Printf("Service %s sent a message to user %s", service, req.UserID)
+		}
 		c.JSON(http.StatusOK, gin.H{"status": "sent"})
-	})
-
-	go r.Run(":8080")
+	}
 }
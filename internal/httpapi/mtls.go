@@ -0,0 +1,42 @@
+package httpapi
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// serviceIdentityKey gin.Context 中保存服务身份的 key
+const serviceIdentityKey = "httpapi.service_identity"
+
+// requireClientCert 要求请求携带经过校验的客户端证书，并把证书 CN 映射为服务身份
+// 证书由 TLS 层按 ClientCAs 校验，这里只检查 CN 是否在允许的列表中
+func requireClientCert(identities map[string]string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tlsState := c.Request.TLS
+		if tlsState == nil || len(tlsState.VerifiedChains) == 0 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "client certificate required"})
+			return
+		}
+		cn := tlsState.VerifiedChains[0][0].Subject.CommonName
+		identity, ok := identities[cn]
+		if !ok {
+			log.Printf("Rejecting client certificate with unknown CN %q", cn)
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "unknown service"})
+			return
+		}
+		c.Set(serviceIdentityKey, identity)
+		c.Next()
+	}
+}
+
+// ServiceIdentity 返回 mtls 中间件认证得到的服务身份
+func ServiceIdentity(c *gin.Context) (string, bool) {
+	identity, ok := c.Get(serviceIdentityKey)
+	if !ok {
+		return "", false
+	}
+	s, ok := identity.(string)
+	return s, ok
+}
@@ -0,0 +1,44 @@
+// 监控指标
+// 职责：通过 expvar 导出连接数、发送队列等运行指标，HTTP 服务的 /metrics 可直接查看。
+package metrics
+
+import (
+	"expvar"
+	"sync/atomic"
+)
+
+var (
+	// OutboundDropped 因发送队列已满被丢弃的帧数
+	OutboundDropped = expvar.NewInt("ws_outbound_dropped")
+	// SlowConsumerDisconnects 因发送队列已满被断开的连接数
+	SlowConsumerDisconnects = expvar.NewInt("ws_slow_consumer_disconnects")
+	// BrokerDropped 因 broker 发布队列已满被丢弃的消息数
+	BrokerDropped = expvar.NewInt("ws_broker_dropped")
+	// EventCount 按命名空间和事件名统计的处理次数，key 为 "命名空间 事件名"
+	EventCount = expvar.NewMap("ws_event_count")
+	// EventDurationMicros 按命名空间和事件名统计的累计处理耗时（微秒），除以 EventCount 得到平均耗时
+	EventDurationMicros = expvar.NewMap("ws_event_duration_us")
+)
+
+// QueueStats 发送队列的统计信息
+type QueueStats struct {
+	Connections int `json:"connections"` // 连接数
+	Queued      int `json:"queued"`      // 所有连接队列中尚未写出的帧数
+	MaxDepth    int `json:"max_depth"`   // 单个连接的最大队列深度
+}
+
+var queueStats atomic.Pointer[func() QueueStats]
+
+func init() {
+	expvar.Publish("ws_outbound_queue", expvar.Func(func() any {
+		if fn := queueStats.Load(); fn != nil {
+			return (*fn)()
+		}
+		return QueueStats{}
+	}))
+}
+
+// SetQueueStats 设置队列统计信息的来源，采集时才会调用
+func SetQueueStats(fn func() QueueStats) {
+	queueStats.Store(&fn)
+}
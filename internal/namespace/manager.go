@@ -0,0 +1,55 @@
+package namespace
+
+import (
+	"sync"
+
+	"github.com/focusandinsist/go-ws-srv/internal/connection"
+)
+
+// Manager 管理所有命名空间
+type Manager struct {
+	namespaces map[string]*Namespace
+	mu         sync.RWMutex
+}
+
+// NewManager 创建命名空间管理器，默认命名空间总是存在
+func NewManager() *Manager {
+	return &Manager{
+		namespaces: map[string]*Namespace{Default: newNamespace(Default)},
+	}
+}
+
+// Of 获取命名空间，不存在时创建
+func (m *Manager) Of(name string) *Namespace {
+	name = Normalize(name)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ns, ok := m.namespaces[name]
+	if !ok {
+		ns = newNamespace(name)
+		m.namespaces[name] = ns
+	}
+	return ns
+}
+
+// Get 获取已注册的命名空间，不存在时返回 nil
+func (m *Manager) Get(name string) *Namespace {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.namespaces[Normalize(name)]
+}
+
+// DisconnectAll 连接关闭时离开所有命名空间
+func (m *Manager) DisconnectAll(client *connection.Client) {
+	m.mu.RLock()
+	namespaces := make([]*Namespace, 0, len(m.namespaces))
+	for _, ns := range m.namespaces {
+		namespaces = append(namespaces, ns)
+	}
+	m.mu.RUnlock()
+
+	for _, ns := range namespaces {
+		ns.Disconnect(client)
+	}
+}
@@ -0,0 +1,151 @@
+// 命名空间 (namespace.go)
+// 职责：类似 Socket.IO 的 namespace，为不同业务（如 /chat、/admin）提供隔离：各自的事件处理器、房间、中间件和授权。
+// 广播、房间消息只会发给同一命名空间内的连接。
+package namespace
+
+import (
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/focusandinsist/go-ws-srv/internal/auth"
+	"github.com/focusandinsist/go-ws-srv/internal/connection"
+	"github.com/focusandinsist/go-ws-srv/internal/event"
+	"github.com/focusandinsist/go-ws-srv/internal/room"
+)
+
+// Default 默认命名空间
+const Default = "/"
+
+var (
+	// ErrUnknownNamespace 命名空间未注册
+	ErrUnknownNamespace = errors.New("unknown namespace")
+	// ErrNotConnected 连接尚未加入该命名空间
+	ErrNotConnected = errors.New("not connected to namespace")
+)
+
+// Authorizer 校验身份是否允许进入命名空间，返回错误则拒绝
+type Authorizer func(*auth.Principal) error
+
+// Middleware 连接加入命名空间时依次执行，返回错误则拒绝
+type Middleware func(*connection.Client) error
+
+// Normalize 规范化命名空间名称，空字符串视为默认命名空间
+func Normalize(name string) string {
+	if name == "" {
+		return Default
+	}
+	if !strings.HasPrefix(name, "/") {
+		return "/" + name
+	}
+	return name
+}
+
+// Namespace 一个命名空间
+type Namespace struct {
+	Name string
+
+	events      *event.EventManager
+	rooms       *room.RoomManager
+	authorize   Authorizer
+	middlewares []Middleware
+	clients     connection.ClientSet // 已加入的连接，分片保存，广播时无锁遍历
+	mu          sync.RWMutex         // 保护 authorize 和 middlewares
+}
+
+func newNamespace(name string) *Namespace {
+	return &Namespace{
+		Name:   name,
+		events: event.NewEventManager(),
+		rooms:  room.NewRoomManager(),
+	}
+}
+
+// On 注册命名空间内的事件处理器，mws 只作用于该事件
+func (ns *Namespace) On(eventType string, handler event.HandlerFunc, mws ...event.Middleware) *Namespace {
+	ns.events.Register(eventType, handler, mws...)
+	return ns
+}
+
+// UseEvents 添加命名空间内所有事件共用的中间件，在全局中间件之后、事件中间件之前执行
+func (ns *Namespace) UseEvents(mws ...event.Middleware) *Namespace {
+	ns.events.Use(mws...)
+	return ns
+}
+
+// Use 添加加入命名空间时执行的中间件
+func (ns *Namespace) Use(mw Middleware) *Namespace {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	ns.middlewares = append(ns.middlewares, mw)
+	return ns
+}
+
+// Authorize 设置命名空间的授权校验
+func (ns *Namespace) Authorize(fn Authorizer) *Namespace {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	ns.authorize = fn
+	return ns
+}
+
+// Events 命名空间的事件管理器
+func (ns *Namespace) Events() *event.EventManager {
+	return ns.events
+}
+
+// Rooms 命名空间的房间管理器
+func (ns *Namespace) Rooms() *room.RoomManager {
+	return ns.rooms
+}
+
+// CheckPrincipal 执行授权校验，握手阶段升级协议前调用
+func (ns *Namespace) CheckPrincipal(p *auth.Principal) error {
+	ns.mu.RLock()
+	authorize := ns.authorize
+	ns.mu.RUnlock()
+	if authorize == nil {
+		return nil
+	}
+	return authorize(p)
+}
+
+// Connect 连接加入命名空间，依次执行授权校验和中间件
+func (ns *Namespace) Connect(client *connection.Client) error {
+	if err := ns.CheckPrincipal(client.Principal); err != nil {
+		return err
+	}
+
+	ns.mu.RLock()
+	middlewares := ns.middlewares
+	ns.mu.RUnlock()
+	for _, mw := range middlewares {
+		if err := mw(client); err != nil {
+			return err
+		}
+	}
+
+	ns.clients.Add(client)
+	return nil
+}
+
+// Disconnect 连接离开命名空间，同时退出其中的所有房间
+func (ns *Namespace) Disconnect(client *connection.Client) {
+	ns.clients.Remove(client)
+	ns.rooms.LeaveAll(client.ID)
+}
+
+// Has 判断连接是否已加入命名空间
+func (ns *Namespace) Has(connID string) bool {
+	return ns.clients.Get(connID) != nil
+}
+
+// Client 获取命名空间内的连接
+func (ns *Namespace) Client(connID string) *connection.Client {
+	return ns.clients.Get(connID)
+}
+
+// ForEach 无锁遍历命名空间内所有连接的快照，用于广播
+func (ns *Namespace) ForEach(fn func(*connection.Client)) {
+	ns.clients.ForEach(fn)
+}
@@ -0,0 +1,115 @@
+package presence
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+// MemoryRegistry 进程内的在线状态，用于本地开发和单节点部署
+type MemoryRegistry struct {
+	ttl time.Duration
+
+	mu    sync.Mutex
+	nodes map[string]time.Time         // nodeID -> 过期时间
+	conns map[string]map[string]string // nodeID -> connID -> userID
+}
+
+// NewMemoryRegistry 创建内存在线状态，节点超过 ttl 没有心跳视为下线
+func NewMemoryRegistry(ttl time.Duration) *MemoryRegistry {
+	return &MemoryRegistry{
+		ttl:   ttl,
+		nodes: make(map[string]time.Time),
+		conns: make(map[string]map[string]string),
+	}
+}
+
+func (r *MemoryRegistry) Register(_ context.Context, nodeID string, conn Conn) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nodeConnsLocked(nodeID)[conn.ConnID] = conn.UserID
+	return nil
+}
+
+func (r *MemoryRegistry) Unregister(_ context.Context, nodeID string, conn Conn) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.conns[nodeID], conn.ConnID)
+	return nil
+}
+
+func (r *MemoryRegistry) Heartbeat(_ context.Context, nodeID string, conns []Conn) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nodes[nodeID] = time.Now().Add(r.ttl)
+	m := make(map[string]string, len(conns))
+	for _, c := range conns {
+		m[c.ConnID] = c.UserID
+	}
+	r.conns[nodeID] = m
+	return nil
+}
+
+func (r *MemoryRegistry) Leave(_ context.Context, nodeID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.nodes, nodeID)
+	delete(r.conns, nodeID)
+	return nil
+}
+
+func (r *MemoryRegistry) Nodes(_ context.Context, userID string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var nodes []string
+	for nodeID, conns := range r.conns {
+		if !r.aliveLocked(nodeID) {
+			continue
+		}
+		for _, u := range conns {
+			if u == userID {
+				nodes = append(nodes, nodeID)
+				break
+			}
+		}
+	}
+	return nodes, nil
+}
+
+func (r *MemoryRegistry) OnlineUsers(context.Context) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var users []string
+	for nodeID, conns := range r.conns {
+		if !r.aliveLocked(nodeID) {
+			continue
+		}
+		for _, u := range conns {
+			users = append(users, u)
+		}
+	}
+	slices.Sort(users)
+	return slices.Compact(users), nil
+}
+
+func (r *MemoryRegistry) Close() error { return nil }
+
+func (r *MemoryRegistry) nodeConnsLocked(nodeID string) map[string]string {
+	m, ok := r.conns[nodeID]
+	if !ok {
+		m = make(map[string]string)
+		r.conns[nodeID] = m
+	}
+	return m
+}
+
+// aliveLocked 过期的节点在这里顺便清理
+func (r *MemoryRegistry) aliveLocked(nodeID string) bool {
+	if time.Now().Before(r.nodes[nodeID]) {
+		return true
+	}
+	delete(r.nodes, nodeID)
+	delete(r.conns, nodeID)
+	return false
+}
@@ -0,0 +1,89 @@
+// 在线状态
+// 职责：记录集群内每个用户的连接分别在哪个节点上，用于查询在线用户和把单聊消息直接路由到目标节点。
+// 每个节点定期发送心跳并重新登记本地连接，节点崩溃后其记录在 TTL 到期后自动失效。
+package presence
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Conn 一个在线连接
+type Conn struct {
+	UserID string
+	ConnID string
+}
+
+// Registry 在线状态存储，所有方法都可以被并发调用
+type Registry interface {
+	// Register 登记 nodeID 上的一个连接
+	Register(ctx context.Context, nodeID string, conn Conn) error
+	// Unregister 删除 nodeID 上的一个连接
+	Unregister(ctx context.Context, nodeID string, conn Conn) error
+	// Heartbeat 续期节点，并重新登记节点上的全部连接，返回前节点在 TTL 内被视为存活
+	Heartbeat(ctx context.Context, nodeID string, conns []Conn) error
+	// Leave 节点下线，立即删除节点及其所有连接
+	Leave(ctx context.Context, nodeID string) error
+	// Nodes 返回持有该用户连接的存活节点，用户不在线时返回空
+	Nodes(ctx context.Context, userID string) ([]string, error)
+	// OnlineUsers 返回所有存活节点上的在线用户，不重复
+	OnlineUsers(ctx context.Context) ([]string, error)
+	Close() error
+}
+
+var (
+	_ Registry = (*MemoryRegistry)(nil)
+	_ Registry = (*RedisRegistry)(nil)
+)
+
+// Heartbeater 按固定间隔调用 Registry.Heartbeat
+type Heartbeater struct {
+	registry Registry
+	nodeID   string
+	interval time.Duration
+	conns    func() []Conn // 本节点当前的全部连接
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewHeartbeater 创建心跳，interval 应明显小于 Registry 的 TTL，通常取 TTL 的三分之一
+func NewHeartbeater(registry Registry, nodeID string, interval time.Duration, conns func() []Conn) *Heartbeater {
+	return &Heartbeater{
+		registry: registry,
+		nodeID:   nodeID,
+		interval: interval,
+		conns:    conns,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Run 立即发送一次心跳，之后按间隔发送，直到 Stop
+func (hb *Heartbeater) Run() {
+	defer close(hb.done)
+	ticker := time.NewTicker(hb.interval)
+	defer ticker.Stop()
+	for {
+		hb.beat()
+		select {
+		case <-hb.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (hb *Heartbeater) beat() {
+	ctx, cancel := context.WithTimeout(context.Background(), hb.interval)
+	defer cancel()
+	if err := hb.registry.Heartbeat(ctx, hb.nodeID, hb.conns()); err != nil {
+		log.Printf("Presence heartbeat failed: %v", err)
+	}
+}
+
+// Stop 停止心跳并等待 Run 返回，只能调用一次
+func (hb *Heartbeater) Stop() {
+	close(hb.stop)
+	<-hb.done
+}
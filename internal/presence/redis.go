@@ -0,0 +1,186 @@
+package presence
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/focusandinsist/go-ws-srv/internal/storage"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// RedisRegistry 基于 Redis 的在线状态，集群内所有节点共享
+//
+//	<prefix>nodes              set，所有登记过的节点
+//	<prefix>node:<id>          节点存活标记，TTL 到期即视为下线
+//	<prefix>node:<id>:conns    hash connID -> userID，节点上的连接
+//	<prefix>user:<id>          hash connID -> nodeID，用户的连接所在的节点
+//
+// 连接记录随心跳续期；节点崩溃后存活标记过期，读取时跳过并顺便清理它留下的记录。
+type RedisRegistry struct {
+	store  *storage.RedisStorage
+	prefix string
+	ttl    time.Duration
+}
+
+// NewRedisRegistry 创建 Redis 在线状态，store 由 registry 持有，Close 时一起关闭
+func NewRedisRegistry(store *storage.RedisStorage, prefix string, ttl time.Duration) (*RedisRegistry, error) {
+	if err := store.Client().Ping(context.Background()).Err(); err != nil {
+		store.Close()
+		return nil, err
+	}
+	return &RedisRegistry{store: store, prefix: prefix, ttl: ttl}, nil
+}
+
+func (r *RedisRegistry) nodesKey() string              { return r.prefix + "nodes" }
+func (r *RedisRegistry) aliveKey(nodeID string) string { return r.prefix + "node:" + nodeID }
+func (r *RedisRegistry) connsKey(nodeID string) string { return r.prefix + "node:" + nodeID + ":conns" }
+func (r *RedisRegistry) userKey(userID string) string  { return r.prefix + "user:" + userID }
+func (r *RedisRegistry) client() *redis.Client         { return r.store.Client() }
+
+func (r *RedisRegistry) Register(ctx context.Context, nodeID string, conn Conn) error {
+	_, err := r.client().TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.HSet(ctx, r.userKey(conn.UserID), conn.ConnID, nodeID)
+		p.Expire(ctx, r.userKey(conn.UserID), r.ttl)
+		p.HSet(ctx, r.connsKey(nodeID), conn.ConnID, conn.UserID)
+		p.Expire(ctx, r.connsKey(nodeID), r.ttl)
+		return nil
+	})
+	return err
+}
+
+func (r *RedisRegistry) Unregister(ctx context.Context, nodeID string, conn Conn) error {
+	_, err := r.client().TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.HDel(ctx, r.userKey(conn.UserID), conn.ConnID)
+		p.HDel(ctx, r.connsKey(nodeID), conn.ConnID)
+		return nil
+	})
+	return err
+}
+
+// Heartbeat 在一个事务里续期节点并整体替换节点的连接列表，Redis 重启丢失数据后也能在一个心跳内恢复
+func (r *RedisRegistry) Heartbeat(ctx context.Context, nodeID string, conns []Conn) error {
+	_, err := r.client().TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.Set(ctx, r.aliveKey(nodeID), time.Now().Unix(), r.ttl)
+		p.SAdd(ctx, r.nodesKey(), nodeID)
+		p.Del(ctx, r.connsKey(nodeID))
+		if len(conns) == 0 {
+			return nil
+		}
+		fields := make([]any, 0, 2*len(conns))
+		for _, c := range conns {
+			fields = append(fields, c.ConnID, c.UserID)
+			p.HSet(ctx, r.userKey(c.UserID), c.ConnID, nodeID)
+			p.Expire(ctx, r.userKey(c.UserID), r.ttl)
+		}
+		p.HSet(ctx, r.connsKey(nodeID), fields...)
+		p.Expire(ctx, r.connsKey(nodeID), r.ttl)
+		return nil
+	})
+	return err
+}
+
+func (r *RedisRegistry) Leave(ctx context.Context, nodeID string) error {
+	conns, err := r.client().HGetAll(ctx, r.connsKey(nodeID)).Result()
+	if err != nil {
+		return err
+	}
+	_, err = r.client().TxPipelined(ctx, func(p redis.Pipeliner) error {
+		for connID, userID := range conns {
+			p.HDel(ctx, r.userKey(userID), connID)
+		}
+		p.Del(ctx, r.connsKey(nodeID), r.aliveKey(nodeID))
+		p.SRem(ctx, r.nodesKey(), nodeID)
+		return nil
+	})
+	return err
+}
+
+func (r *RedisRegistry) Nodes(ctx context.Context, userID string) ([]string, error) {
+	conns, err := r.client().HGetAll(ctx, r.userKey(userID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	var candidates []string
+	for _, nodeID := range conns {
+		if !slices.Contains(candidates, nodeID) {
+			candidates = append(candidates, nodeID)
+		}
+	}
+	alive, err := r.alive(ctx, candidates)
+	if err != nil {
+		return nil, err
+	}
+
+	// 清理已下线节点留下的连接
+	var stale []string
+	for connID, nodeID := range conns {
+		if !alive[nodeID] {
+			stale = append(stale, connID)
+		}
+	}
+	if len(stale) > 0 {
+		r.client().HDel(ctx, r.userKey(userID), stale...)
+	}
+
+	nodes := make([]string, 0, len(candidates))
+	for _, nodeID := range candidates {
+		if alive[nodeID] {
+			nodes = append(nodes, nodeID)
+		}
+	}
+	return nodes, nil
+}
+
+func (r *RedisRegistry) OnlineUsers(ctx context.Context) ([]string, error) {
+	nodeIDs, err := r.client().SMembers(ctx, r.nodesKey()).Result()
+	if err != nil {
+		return nil, err
+	}
+	alive, err := r.alive(ctx, nodeIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var users []string
+	for _, nodeID := range nodeIDs {
+		if !alive[nodeID] {
+			r.client().SRem(ctx, r.nodesKey(), nodeID)
+			continue
+		}
+		vals, err := r.client().HVals(ctx, r.connsKey(nodeID)).Result()
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, vals...)
+	}
+	slices.Sort(users)
+	return slices.Compact(users), nil
+}
+
+func (r *RedisRegistry) Close() error {
+	return r.store.Close()
+}
+
+// alive 批量检查节点的存活标记
+func (r *RedisRegistry) alive(ctx context.Context, nodeIDs []string) (map[string]bool, error) {
+	alive := make(map[string]bool, len(nodeIDs))
+	if len(nodeIDs) == 0 {
+		return alive, nil
+	}
+	cmds := make([]*redis.IntCmd, len(nodeIDs))
+	_, err := r.client().Pipelined(ctx, func(p redis.Pipeliner) error {
+		for i, nodeID := range nodeIDs {
+			cmds[i] = p.Exists(ctx, r.aliveKey(nodeID))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, nodeID := range nodeIDs {
+		alive[nodeID] = cmds[i].Val() > 0
+	}
+	return alive, nil
+}
@@ -0,0 +1,90 @@
+// 文件重新加载
+// 职责：从文件加载的配置（JWT 公钥、TLS 证书等）在文件变化后自动重新加载。
+// 不启动后台协程，读取方使用时顺带检查，最多每 checkInterval 检查一次文件的修改时间和大小。
+package reload
+
+import (
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// checkInterval 两次检查文件是否变化的最小间隔
+const checkInterval = time.Second
+
+// Reloader 从一组文件加载出的值，任一文件的修改时间或大小变化后调用 load 重新加载
+// 重新加载失败时继续使用旧值，避免文件写到一半（或几个文件分几次写入）时拒绝所有请求
+type Reloader[T any] struct {
+	paths []string
+	load  func() (T, error)
+
+	mu      sync.RWMutex
+	value   T
+	loaded  bool
+	stamps  []fileStamp
+	checked time.Time
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// New 创建 Reloader，paths 为 load 读取的文件；不会立即加载，启动时应先调用一次 Load
+func New[T any](load func() (T, error), paths ...string) *Reloader[T] {
+	return &Reloader[T]{paths: paths, load: load}
+}
+
+// Load 检查文件是否变化，变化时重新加载；还没有加载成功过时总是加载
+func (r *Reloader[T]) Load() error {
+	stamps := make([]fileStamp, len(r.paths))
+	for i, path := range r.paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		stamps[i] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+	}
+
+	r.mu.RLock()
+	unchanged := r.loaded && slices.Equal(r.stamps, stamps)
+	r.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	value, err := r.load()
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.value = value
+	r.loaded = true
+	r.stamps = stamps
+	r.mu.Unlock()
+	return nil
+}
+
+// Get 返回当前的值，距上次检查超过 checkInterval 时先检查文件是否变化
+func (r *Reloader[T]) Get() T {
+	r.mu.Lock()
+	due := time.Since(r.checked) >= checkInterval
+	if due {
+		r.checked = time.Now()
+	}
+	r.mu.Unlock()
+
+	if due {
+		if err := r.Load(); err != nil {
+			log.Printf("Error reloading %s: %v", strings.Join(r.paths, ", "), err)
+		}
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.value
+}
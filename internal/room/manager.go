@@ -7,14 +7,16 @@ import (
 
 // RoomManager 管理多个房间
 type RoomManager struct {
-	rooms map[string]*Room
-	mu    sync.Mutex
+	rooms       map[string]*Room
+	memberships map[string]map[string]struct{} // 连接 ID -> 加入的房间，连接断开时据此退出所有房间
+	mu          sync.Mutex
 }
 
 // NewRoomManager 创建房间管理器
 func NewRoomManager() *RoomManager {
 	return &RoomManager{
-		rooms: make(map[string]*Room),
+		rooms:       make(map[string]*Room),
+		memberships: make(map[string]map[string]struct{}),
 	}
 }
 
@@ -47,5 +49,99 @@ func (rm *RoomManager) GetRoom(name string) *Room {
 func (rm *RoomManager) DeleteRoom(name string) {
 	rm.mu.Lock()
 	defer rm.mu.Unlock()
+	room, ok := rm.rooms[name]
+	if !ok {
+		return
+	}
+	for _, connID := range room.GetMembers() {
+		rm.forgetLocked(connID, name)
+	}
 	delete(rm.rooms, name)
 }
+
+// Join 连接加入房间，房间不存在时自动创建
+func (rm *RoomManager) Join(name, connID string) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	room, ok := rm.rooms[name]
+	if !ok {
+		room = NewRoom(name)
+		rm.rooms[name] = room
+	}
+	room.AddMember(connID)
+
+	joined, ok := rm.memberships[connID]
+	if !ok {
+		joined = make(map[string]struct{})
+		rm.memberships[connID] = joined
+	}
+	joined[name] = struct{}{}
+}
+
+// Leave 连接退出房间，房间空了之后自动删除
+func (rm *RoomManager) Leave(name, connID string) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	rm.leaveLocked(name, connID)
+}
+
+// LeaveAll 连接退出所有房间，返回退出的房间
+func (rm *RoomManager) LeaveAll(connID string) []string {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	var left []string
+	for name := range rm.memberships[connID] {
+		left = append(left, name)
+		rm.leaveLocked(name, connID)
+	}
+	return left
+}
+
+// Rooms 获取连接加入的所有房间
+func (rm *RoomManager) Rooms(connID string) []string {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	rooms := make([]string, 0, len(rm.memberships[connID]))
+	for name := range rm.memberships[connID] {
+		rooms = append(rooms, name)
+	}
+	return rooms
+}
+
+// Members 获取房间内的所有连接 ID，房间不存在时返回 nil
+func (rm *RoomManager) Members(name string) []string {
+	rm.mu.Lock()
+	room := rm.rooms[name]
+	rm.mu.Unlock()
+	return room.GetMembers()
+}
+
+// IsMember 判断连接是否在房间中
+func (rm *RoomManager) IsMember(name, connID string) bool {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	_, ok := rm.memberships[connID][name]
+	return ok
+}
+
+func (rm *RoomManager) leaveLocked(name, connID string) {
+	room, ok := rm.rooms[name]
+	if ok {
+		room.RemoveMember(connID)
+		if room.Size() == 0 {
+			delete(rm.rooms, name)
+		}
+	}
+	rm.forgetLocked(connID, name)
+}
+
+func (rm *RoomManager) forgetLocked(connID, name string) {
+	joined := rm.memberships[connID]
+	delete(joined, name)
+	if len(joined) == 0 {
+		delete(rm.memberships, connID)
+	}
+}
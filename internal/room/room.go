@@ -4,8 +4,8 @@ import "sync"
 
 // Room 代表一个聊天房间
 type Room struct {
-	Name    string   // 房间名称
-	Members []string // 房间成员（用户 ID）
+	Name    string              // 房间名称
+	members map[string]struct{} // 房间成员（连接 ID）
 	mu      sync.Mutex
 }
 
@@ -13,38 +13,61 @@ type Room struct {
 func NewRoom(name string) *Room {
 	return &Room{
 		Name:    name,
-		Members: make([]string, 0),
+		members: make(map[string]struct{}),
 	}
 }
 
 // AddMember 添加成员到房间
-func (r *Room) AddMember(userID string) {
+func (r *Room) AddMember(connID string) {
 	if r == nil {
 		return
 	}
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	r.Members = append(r.Members, userID)
+	r.members[connID] = struct{}{}
 }
 
 // RemoveMember 从房间移除成员
-func (r *Room) RemoveMember(userID string) {
+func (r *Room) RemoveMember(connID string) {
 	if r == nil {
 		return
 	}
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	for i, member := range r.Members {
-		if member == userID {
-			r.Members = append(r.Members[:i], r.Members[i+1:]...)
-			break
-		}
+	delete(r.members, connID)
+}
+
+// HasMember 判断连接是否在房间中
+func (r *Room) HasMember(connID string) bool {
+	if r == nil {
+		return false
 	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.members[connID]
+	return ok
 }
 
 // GetMembers 获取房间成员
 func (r *Room) GetMembers() []string {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	members := make([]string, 0, len(r.members))
+	for id := range r.members {
+		members = append(members, id)
+	}
+	return members
+}
+
+// Size 房间成员数
+func (r *Room) Size() int {
+	if r == nil {
+		return 0
+	}
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	return r.Members
+	return len(r.members)
 }
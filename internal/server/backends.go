@@ -0,0 +1,76 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/focusandinsist/go-ws-srv/config"
+	"github.com/focusandinsist/go-ws-srv/internal/broker"
+	"github.com/focusandinsist/go-ws-srv/internal/presence"
+	"github.com/focusandinsist/go-ws-srv/internal/storage"
+)
+
+// newBroker 按配置创建消息分发
+func newBroker(cfg config.BrokerConfig, nodeID string) (broker.Broker, error) {
+	switch cfg.Type {
+	case config.BackendMemory, "":
+		return broker.NewMemoryBroker(), nil
+	case config.BackendKafka:
+		kafkaBroker, err := broker.NewKafkaBroker(cfg.KafkaBrokers, cfg.KafkaTopic, cfg.KafkaGroupPrefix+nodeID, nodeID)
+		if err != nil {
+			return nil, fmt.Errorf("create Kafka broker: %w", err)
+		}
+		return kafkaBroker, nil
+	case config.BackendRedis:
+		redisBroker, err := broker.NewRedisBroker(storage.NewRedisStorage(cfg.RedisAddr), cfg.RedisPrefix, nodeID)
+		if err != nil {
+			return nil, fmt.Errorf("create Redis broker: %w", err)
+		}
+		return redisBroker, nil
+	default:
+		return nil, fmt.Errorf("unknown broker type %q", cfg.Type)
+	}
+}
+
+// newOfflineStore 按配置创建离线消息队列
+func newOfflineStore(cfg config.StorageConfig) (storage.OfflineStore, error) {
+	switch cfg.Offline {
+	case config.BackendMemory, "":
+		return storage.NewMemoryOfflineStore(), nil
+	case config.BackendRedis:
+		return storage.NewRedisStorage(cfg.RedisAddr), nil
+	default:
+		return nil, fmt.Errorf("unknown offline store %q", cfg.Offline)
+	}
+}
+
+// newHistoryStore 按配置创建历史消息存储
+func newHistoryStore(cfg config.StorageConfig) (storage.HistoryStore, error) {
+	switch cfg.History {
+	case config.BackendMemory, "":
+		return storage.NewMemoryHistoryStore(), nil
+	case config.BackendMongo:
+		mongoStorage, err := storage.NewMongoStorage(cfg.MongoURI, cfg.MongoDB, cfg.MongoCollection)
+		if err != nil {
+			return nil, fmt.Errorf("create MongoDB storage: %w", err)
+		}
+		return mongoStorage, nil
+	default:
+		return nil, fmt.Errorf("unknown history store %q", cfg.History)
+	}
+}
+
+// newPresence 按配置创建在线状态
+func newPresence(cfg config.PresenceConfig) (presence.Registry, error) {
+	switch cfg.Type {
+	case config.BackendMemory, "":
+		return presence.NewMemoryRegistry(cfg.TTL), nil
+	case config.BackendRedis:
+		registry, err := presence.NewRedisRegistry(storage.NewRedisStorage(cfg.RedisAddr), cfg.RedisPrefix, cfg.TTL)
+		if err != nil {
+			return nil, fmt.Errorf("create Redis presence: %w", err)
+		}
+		return registry, nil
+	default:
+		return nil, fmt.Errorf("unknown presence type %q", cfg.Type)
+	}
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"github.com/focusandinsist/go-ws-srv/config"
+	"github.com/focusandinsist/go-ws-srv/internal/auth"
+	"github.com/focusandinsist/go-ws-srv/internal/connection"
+	"github.com/focusandinsist/go-ws-srv/internal/delivery"
+)
+
+// connectionOptions 把配置转换成连接参数，策略名已由 config.Validate 校验
+func connectionOptions(cfg config.ConnectionConfig) connection.Options {
+	opts := connection.Options{
+		MaxDevices:   cfg.MaxDevices,
+		DevicePolicy: connection.KickOldest,
+		QueueSize:    cfg.QueueSize,
+		SendPolicy:   connection.DropOldest,
+		WriteTimeout: cfg.WriteTimeout,
+		PingInterval: cfg.PingInterval,
+		PongTimeout:  cfg.PongTimeout,
+	}
+	if cfg.DevicePolicy == config.DeviceRejectNewest {
+		opts.DevicePolicy = connection.RejectNewest
+	}
+	switch cfg.SendPolicy {
+	case config.SendDropNewest:
+		opts.SendPolicy = connection.DropNewest
+	case config.SendDisconnectSlow:
+		opts.SendPolicy = connection.DisconnectSlow
+	}
+	return opts
+}
+
+// authOptions 把配置转换成认证参数
+func authOptions(cfg config.AuthConfig) auth.Options {
+	return auth.Options{
+		Secret:        cfg.Secret,
+		PublicKeyFile: cfg.PublicKeyFile,
+		JWKSFile:      cfg.JWKSFile,
+		Issuer:        cfg.Issuer,
+		Audience:      cfg.Audience,
+		Leeway:        cfg.Leeway,
+	}
+}
+
+// deliveryOptions 把配置转换成重发参数
+func deliveryOptions(cfg config.DeliveryConfig) delivery.Options {
+	return delivery.Options{
+		InitialBackoff: cfg.InitialBackoff,
+		MaxBackoff:     cfg.MaxBackoff,
+		TTL:            cfg.TTL,
+	}
+}
@@ -8,84 +8,223 @@
 package server
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"time"
 
+	"github.com/focusandinsist/go-ws-srv/config"
 	"github.com/focusandinsist/go-ws-srv/internal/auth"
 	"github.com/focusandinsist/go-ws-srv/internal/broker"
 	"github.com/focusandinsist/go-ws-srv/internal/connection"
+	"github.com/focusandinsist/go-ws-srv/internal/delivery"
+	"github.com/focusandinsist/go-ws-srv/internal/event"
 	"github.com/focusandinsist/go-ws-srv/internal/handler"
 	"github.com/focusandinsist/go-ws-srv/internal/httpapi"
 	"github.com/focusandinsist/go-ws-srv/internal/message"
-	"github.com/focusandinsist/go-ws-srv/internal/room"
+	"github.com/focusandinsist/go-ws-srv/internal/presence"
 	"github.com/focusandinsist/go-ws-srv/internal/storage"
+	"github.com/focusandinsist/go-ws-srv/internal/tlsconfig"
+	"github.com/focusandinsist/go-ws-srv/protocol"
+
+	"github.com/google/uuid"
 )
 
 type Server struct {
 	connMgr      *connection.ConnectionManager
 	msgMgr       *message.MessageManager
 	authMgr      *auth.AuthManager
-	roomMgr      *room.RoomManager
 	handler      *handler.Handler
 	server       *http.Server
-	kafkaBroker  *broker.KafkaBroker
-	redisStorage *storage.RedisStorage
-	mongoStorage *storage.MongoStorage
+	broker       broker.Broker
+	offlineStore storage.OfflineStore
+	historyStore storage.HistoryStore
+	presence     presence.Registry
+	heartbeater  *presence.Heartbeater
+	nodeID       string
+
+	reconnectDelay time.Duration // 停机时提示客户端重连的最长等待时间
 }
 
-func NewServer() *Server {
+func NewServer(cfg *config.Config) (*Server, error) {
 	// 初始化各个管理器
-	connMgr := connection.NewConnectionManager()
+	connMgr := connection.NewConnectionManager(connectionOptions(cfg.Connection))
 	msgMgr := message.NewMessageManager()
-	authMgr := auth.NewAuthManager()
-	roomMgr := room.NewRoomManager()
-	kafkaBroker, err := broker.NewKafkaBroker([]string{"localhost:9092"}, "websocket-messages")
+	authenticator, err := auth.NewAuth(authOptions(cfg.Auth))
+	if err != nil {
+		return nil, fmt.Errorf("create authenticator: %w", err)
+	}
+	authMgr := auth.NewAuthManager(authenticator)
+
+	nodeID := cfg.Cluster.NodeID
+	if nodeID == "" {
+		nodeID = generateNodeID()
+	}
+	log.Printf("Node ID: %s", nodeID)
+
+	// 按配置选择存储、消息分发和在线状态的后端
+	msgBroker, err := newBroker(cfg.Broker, nodeID)
+	if err != nil {
+		return nil, err
+	}
+	offlineStore, err := newOfflineStore(cfg.Storage)
 	if err != nil {
-		log.Fatalf("Failed to create Kafka broker: %v", err)
+		return nil, err
 	}
-	redisStorage := storage.NewRedisStorage("localhost:6379")
-	mongoStorage, err := storage.NewMongoStorage("mongodb://localhost:27017", "chatDB", "messages")
+	historyStore, err := newHistoryStore(cfg.Storage)
 	if err != nil {
-		log.Fatalf("Failed to create MongoDB storage: %v", err)
+		return nil, err
+	}
+	registry, err := newPresence(cfg.Presence)
+	if err != nil {
+		return nil, err
 	}
 
 	// 创建 WebSocket 处理器
-	wsHandler := handler.NewHandler(connMgr, msgMgr, authMgr, roomMgr, kafkaBroker, redisStorage, mongoStorage)
+	protocol.AckManager.SetTTL(cfg.Ack.Timeout)
+	tracker := delivery.NewTracker(deliveryOptions(cfg.Delivery))
+	wsHandler := handler.NewHandler(connMgr, msgMgr, authMgr, msgBroker, offlineStore, historyStore, tracker, nodeID, registry)
+
+	wsHandler.SetEventTimeout(cfg.Connection.EventTimeout)
+
+	// 所有事件共用的中间件：panic 恢复在最外层，超过 connection.slow_event 的事件记录慢日志
+	wsHandler.Use(event.Recover(), event.Timing(cfg.Connection.SlowEvent), event.Logging())
 
-	// 注册事件处理器
-	wsHandler.RegisterEventHandler("broadcast", wsHandler.BroadcastMessage)
-	wsHandler.RegisterEventHandler("direct", wsHandler.SendDirectMessage)
+	// 注册命名空间和各自的事件处理器，/admin 只允许 admin 角色加入
+	wsHandler.Of("/admin").Authorize(func(p *auth.Principal) error {
+		if !p.HasRole("admin") {
+			return errors.New("admin role required")
+		}
+		return nil
+	})
+	for _, name := range []string{"/", "/chat", "/admin"} {
+		wsHandler.Of(name).
+			On("broadcast", wsHandler.BroadcastMessage).
+			On("direct", wsHandler.SendDirectMessage).
+			On(handler.EventRoom, wsHandler.RoomMessage)
+	}
+
+	// WebSocket、REST API、健康检查和监控指标共用一个路由
+	router, err := httpapi.NewRouter(httpapi.Options{
+		OnlineUsers: registry.OnlineUsers,
+		Push:        wsHandler.Push,
+		WebSocket:   wsHandler.HandleWebSocket,
+		Ready: func() error {
+			if wsHandler.Draining() {
+				return errors.New("server is shutting down")
+			}
+			return nil
+		},
+		Middleware:        cfg.Server.Middleware,
+		ServiceIdentities: cfg.Server.ServiceIdentities,
+	})
+	if err != nil {
+		return nil, err
+	}
 
 	// 创建 HTTP 服务器
 	server := &http.Server{
-		Addr:         ":8080",
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 10 * time.Second,
+		Addr:         cfg.Server.Addr,
+		Handler:      router,
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout,
+	}
+	if cfg.Server.TLSCertFile != "" {
+		server.TLSConfig, err = tlsconfig.New(tlsconfig.Options{
+			CertFile:     cfg.Server.TLSCertFile,
+			KeyFile:      cfg.Server.TLSKeyFile,
+			ClientCAFile: cfg.Server.TLSClientCAFile,
+		})
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	return &Server{
-		connMgr:      connMgr,
-		msgMgr:       msgMgr,
-		authMgr:      authMgr,
-		roomMgr:      roomMgr,
-		handler:      wsHandler,
-		server:       server,
-		kafkaBroker:  kafkaBroker,
-		redisStorage: redisStorage,
-		mongoStorage: mongoStorage,
-	}
+		connMgr:        connMgr,
+		msgMgr:         msgMgr,
+		authMgr:        authMgr,
+		handler:        wsHandler,
+		server:         server,
+		broker:         msgBroker,
+		offlineStore:   offlineStore,
+		historyStore:   historyStore,
+		presence:       registry,
+		heartbeater:    presence.NewHeartbeater(registry, nodeID, cfg.Presence.TTL/3, wsHandler.PresenceConns),
+		nodeID:         nodeID,
+		reconnectDelay: cfg.Server.ReconnectDelay,
+	}, nil
 }
 
+// Start 在 addr 上提供服务，配置了证书时使用 TLS；停机后返回 http.ErrServerClosed
 func (s *Server) Start(addr string) error {
-	http.HandleFunc("/ws", s.handler.HandleWebSocket)
-	httpapi.RunHTTPServer(s.connMgr)
+	// 定期续期本节点的在线状态
+	go s.heartbeater.Run()
+
+	// 接收其他节点转发的消息
+	go func() {
+		if err := s.handler.ConsumeCluster(); err != nil {
+			log.Printf("Cluster consumer stopped: %v", err)
+		}
+	}()
+
 	s.server.Addr = addr
+	if s.server.TLSConfig != nil {
+		// 证书由 TLSConfig.GetCertificate 提供，支持热更新
+		log.Printf("Listening on %s (TLS)", addr)
+		return s.server.ListenAndServeTLS("", "")
+	}
+	log.Printf("Listening on %s", addr)
 	return s.server.ListenAndServe()
 }
 
-func (s *Server) Shutdown() {
-	log.Println("Closing all connections...")
-	s.connMgr.CloseAllConnections()
+// Shutdown 优雅停机：停止接受新连接，通知客户端重连并等待发送队列排空，
+// 然后停止 HTTP 服务，发出 broker 中缓冲的消息，最后关闭存储。ctx 控制排空连接的最长时间
+func (s *Server) Shutdown(ctx context.Context) error {
+	var errs []error
+	if err := s.handler.Drain(ctx, s.reconnectDelay); err != nil {
+		errs = append(errs, fmt.Errorf("drain connections: %w", err))
+	}
+	// WebSocket 连接已经清理完毕，这里等待进行中的 REST 请求结束，ctx 到期则直接关闭
+	if err := s.server.Shutdown(ctx); err != nil {
+		s.server.Close()
+		errs = append(errs, fmt.Errorf("shutdown http server: %w", err))
+	}
 	s.msgMgr.Shutdown()
+
+	// 连接都已注销，停止心跳并立即删除本节点，不必等 TTL 过期
+	s.heartbeater.Stop()
+	leaveCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.presence.Leave(leaveCtx, s.nodeID); err != nil {
+		errs = append(errs, fmt.Errorf("leave presence: %w", err))
+	}
+
+	log.Println("Flushing broker...")
+	if err := s.broker.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("close broker: %w", err))
+	}
+	log.Println("Closing storage...")
+	if err := s.offlineStore.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("close offline store: %w", err))
+	}
+	if err := s.historyStore.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("close history store: %w", err))
+	}
+	if err := s.presence.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("close presence: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// generateNodeID 用主机名加随机后缀生成节点 ID，同一主机上的多个进程也不会冲突
+func generateNodeID() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "node"
+	}
+	return host + "-" + uuid.NewString()[:8]
 }
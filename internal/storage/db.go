@@ -1 +1,37 @@
-package storage
\ No newline at end of file
+// 存储层接口
+// 职责：定义离线消息和历史消息的存储接口，Redis/MongoDB 和内存实现都满足这些接口，
+// 本地开发、单元测试和单节点部署可以直接使用内存实现，不依赖外部服务。
+package storage
+
+import (
+	"context"
+
+	"github.com/focusandinsist/go-ws-srv/protocol"
+)
+
+// OfflineStore 离线消息队列，按用户保存尚未送达的消息，先进先出
+type OfflineStore interface {
+	AddOfflineMessage(userID string, message string) error
+	GetOfflineMessages(userID string) ([]string, error)
+	// TrimOfflineMessages 删除最前面的 n 条消息
+	TrimOfflineMessages(userID string, n int) error
+	ClearOfflineMessages(userID string) error
+	Close() error
+}
+
+// HistoryStore 历史消息存储，按会话和序号查询
+type HistoryStore interface {
+	StoreMessage(msg *protocol.Message) error
+	// NextSeq 原子地为会话分配下一个序号，从 1 开始
+	NextSeq(ctx context.Context, convID string) (int64, error)
+	// GetConversation 按序号升序返回会话中序号大于 afterSeq 的消息，最多 limit 条
+	GetConversation(ctx context.Context, convID string, afterSeq int64, limit int) ([]*protocol.Message, error)
+	Close() error
+}
+
+var (
+	_ OfflineStore = (*RedisStorage)(nil)
+	_ OfflineStore = (*MemoryOfflineStore)(nil)
+	_ HistoryStore = (*MongoStorage)(nil)
+	_ HistoryStore = (*MemoryHistoryStore)(nil)
+)
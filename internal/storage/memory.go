@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/focusandinsist/go-ws-srv/protocol"
+)
+
+// MemoryOfflineStore 进程内的离线消息队列，进程重启后丢失
+type MemoryOfflineStore struct {
+	queues map[string][]string
+	mu     sync.Mutex
+}
+
+// NewMemoryOfflineStore 创建内存离线消息队列
+func NewMemoryOfflineStore() *MemoryOfflineStore {
+	return &MemoryOfflineStore{queues: make(map[string][]string)}
+}
+
+func (s *MemoryOfflineStore) AddOfflineMessage(userID string, message string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queues[userID] = append(s.queues[userID], message)
+	return nil
+}
+
+func (s *MemoryOfflineStore) GetOfflineMessages(userID string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.queues[userID]...), nil
+}
+
+func (s *MemoryOfflineStore) TrimOfflineMessages(userID string, n int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	queue := s.queues[userID]
+	if n >= len(queue) {
+		delete(s.queues, userID)
+		return nil
+	}
+	s.queues[userID] = append([]string(nil), queue[n:]...)
+	return nil
+}
+
+func (s *MemoryOfflineStore) ClearOfflineMessages(userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.queues, userID)
+	return nil
+}
+
+// MemoryHistoryStore 进程内的历史消息存储，只保存属于会话的消息，进程重启后丢失
+type MemoryHistoryStore struct {
+	conversations map[string][]*protocol.Message // 会话 ID -> 按序号升序的消息
+	seqs          map[string]int64               // 会话 ID -> 已分配的最大序号
+	mu            sync.Mutex
+}
+
+// NewMemoryHistoryStore 创建内存历史消息存储
+func NewMemoryHistoryStore() *MemoryHistoryStore {
+	return &MemoryHistoryStore{
+		conversations: make(map[string][]*protocol.Message),
+		seqs:          make(map[string]int64),
+	}
+}
+
+func (s *MemoryHistoryStore) StoreMessage(msg *protocol.Message) error {
+	if msg.ConvID == "" {
+		return nil
+	}
+	stored := *msg
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// 序号分配和写入之间可能交错，按序号插入保证有序
+	msgs := s.conversations[msg.ConvID]
+	i := sort.Search(len(msgs), func(i int) bool { return msgs[i].Seq > msg.Seq })
+	msgs = append(msgs, nil)
+	copy(msgs[i+1:], msgs[i:])
+	msgs[i] = &stored
+	s.conversations[msg.ConvID] = msgs
+	return nil
+}
+
+func (s *MemoryHistoryStore) NextSeq(_ context.Context, convID string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seqs[convID]++
+	return s.seqs[convID], nil
+}
+
+func (s *MemoryHistoryStore) GetConversation(_ context.Context, convID string, afterSeq int64, limit int) ([]*protocol.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	msgs := s.conversations[convID]
+	i := sort.Search(len(msgs), func(i int) bool { return msgs[i].Seq > afterSeq })
+
+	result := make([]*protocol.Message, 0, min(limit, len(msgs)-i))
+	for _, m := range msgs[i:] {
+		if len(result) == limit {
+			break
+		}
+		copied := *m
+		result = append(result, &copied)
+	}
+	return result, nil
+}
+
+// Close 内存实现没有需要释放的资源
+func (s *MemoryOfflineStore) Close() error { return nil }
+
+// Close 内存实现没有需要释放的资源
+func (s *MemoryHistoryStore) Close() error { return nil }
@@ -4,6 +4,9 @@ import (
 	"context"
 	"time"
 
+	"github.com/focusandinsist/go-ws-srv/protocol"
+
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -11,6 +14,7 @@ import (
 type MongoStorage struct {
 	client     *mongo.Client
 	collection *mongo.Collection
+	counters   *mongo.Collection // 每个会话当前的最大序号
 }
 
 func NewMongoStorage(uri, dbName, collectionName string) (*MongoStorage, error) {
@@ -30,10 +34,24 @@ func NewMongoStorage(uri, dbName, collectionName string) (*MongoStorage, error)
 	}
 
 	collection := client.Database(dbName).Collection(collectionName)
-	return &MongoStorage{client: client, collection: collection}, nil
+
+	// 按会话和序号查询历史消息
+	_, err = collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "conv_id", Value: 1}, {Key: "seq", Value: 1}},
+		Options: options.Index().SetPartialFilterExpression(bson.M{"conv_id": bson.M{"$exists": true}}).SetUnique(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &MongoStorage{
+		client:     client,
+		collection: collection,
+		counters:   client.Database(dbName).Collection(collectionName + "_seq"),
+	}, nil
 }
 
-func (ms *MongoStorage) StoreMessage(msg any) error {
+func (ms *MongoStorage) StoreMessage(msg *protocol.Message) error {
 	_, err := ms.collection.InsertOne(context.Background(), msg)
 	return err
 }
@@ -55,3 +73,41 @@ func (ms *MongoStorage) GetMessages(filter any) ([]any, error) {
 	}
 	return messages, nil
 }
+
+// NextSeq 原子地为会话分配下一个序号，从 1 开始
+func (ms *MongoStorage) NextSeq(ctx context.Context, convID string) (int64, error) {
+	var counter struct {
+		Seq int64 `bson:"seq"`
+	}
+	err := ms.counters.FindOneAndUpdate(ctx,
+		bson.M{"_id": convID},
+		bson.M{"$inc": bson.M{"seq": 1}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&counter)
+	return counter.Seq, err
+}
+
+// GetConversation 按序号升序返回会话中序号大于 afterSeq 的消息，最多 limit 条
+func (ms *MongoStorage) GetConversation(ctx context.Context, convID string, afterSeq int64, limit int) ([]*protocol.Message, error) {
+	cursor, err := ms.collection.Find(ctx,
+		bson.M{"conv_id": convID, "seq": bson.M{"$gt": afterSeq}},
+		options.Find().SetSort(bson.D{{Key: "seq", Value: 1}}).SetLimit(int64(limit)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var messages []*protocol.Message
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// Close 断开 MongoDB 连接
+func (ms *MongoStorage) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return ms.client.Disconnect(ctx)
+}
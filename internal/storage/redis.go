@@ -17,6 +17,11 @@ func NewRedisStorage(addr string) *RedisStorage {
 	return &RedisStorage{client: client}
 }
 
+// Client 底层的 Redis 客户端，供 Pub/Sub 等其他基于 Redis 的组件复用
+func (rs *RedisStorage) Client() *redis.Client {
+	return rs.client
+}
+
 func (rs *RedisStorage) Set(key string, value interface{}) error {
 	return rs.client.Set(context.Background(), key, value, 0).Err()
 }
@@ -36,3 +41,13 @@ func (rs *RedisStorage) GetOfflineMessages(userID string) ([]string, error) {
 func (rs *RedisStorage) ClearOfflineMessages(userID string) error {
 	return rs.client.Del(context.Background(), "offline:"+userID).Err()
 }
+
+// TrimOfflineMessages 删除离线队列最前面的 n 条消息，用于补发成功后只删除已补发的部分
+func (rs *RedisStorage) TrimOfflineMessages(userID string, n int) error {
+	return rs.client.LTrim(context.Background(), "offline:"+userID, int64(n), -1).Err()
+}
+
+// Close 关闭 Redis 连接
+func (rs *RedisStorage) Close() error {
+	return rs.client.Close()
+}
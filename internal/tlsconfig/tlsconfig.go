@@ -0,0 +1,60 @@
+// TLS 配置
+// 职责：为 HTTP 服务提供 TLS/WSS。证书和私钥文件变化后自动重新加载，新握手使用新证书，已建立的连接不受影响；
+// 可选地校验客户端证书（mTLS），供内部服务调用 REST API 时认证身份。
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/focusandinsist/go-ws-srv/internal/reload"
+)
+
+// Options TLS 配置
+type Options struct {
+	CertFile     string // 服务端证书，PEM
+	KeyFile      string // 服务端私钥，PEM
+	ClientCAFile string // 签发客户端证书的 CA，为空则不请求客户端证书
+}
+
+// New 创建 tls.Config，启动时先加载一次证书，尽早暴露配置错误
+// 配置了 ClientCAFile 时，客户端可以出示证书但不强制，WebSocket 客户端仍然用 JWT 认证，
+// 需要 mTLS 的路由自行检查 VerifiedChains
+func New(opts Options) (*tls.Config, error) {
+	// 证书和私钥任一文件变化后重新加载；两个文件分两次写入时可能暂时不匹配，此时继续使用旧证书
+	certs := reload.New(func() (*tls.Certificate, error) {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Loaded TLS certificate from %s", opts.CertFile)
+		return &cert, nil
+	}, opts.CertFile, opts.KeyFile)
+	if err := certs.Load(); err != nil {
+		return nil, fmt.Errorf("tls: load certificate: %w", err)
+	}
+
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return certs.Get(), nil
+		},
+	}
+	if opts.ClientCAFile != "" {
+		pem, err := os.ReadFile(opts.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls: read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("tls: no certificates found in client CA file")
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return cfg, nil
+}
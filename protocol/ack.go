@@ -1,69 +1,161 @@
 package protocol
 
 import (
+	"context"
 	"errors"
 	"sync"
 	"time"
+)
+
+// EventAck ack 帧的事件名，AckID 与被确认的消息一致，Data 为回复内容
+const EventAck = "__ack__"
+
+// 应用层心跳，服务端收到 ping 后回复 pong，AckID 和 Data 原样带回
+const (
+	EventPing = "ping"
+	EventPong = "pong"
+)
 
-	"github.com/google/uuid"
+var (
+	// ErrAckTimeout 等待 ack 超时
+	ErrAckTimeout = errors.New("ack timeout")
+	// ErrAckCanceled 等待被取消，通常是连接已关闭
+	ErrAckCanceled = errors.New("ack canceled")
 )
 
 type ackEntry struct {
-	ch   chan struct{}
-	once sync.Once
+	owner string
+	ch    chan *Message // 容量为 1，收到 ack 时写入，取消时关闭
 }
 
 type ackManager struct {
-	mu   sync.Mutex
-	acks map[string]*ackEntry
-	ttl  time.Duration
-	// acks sync.Map // key: string -> chan *Message // TODO 这个是合理方案
+	mu     sync.Mutex
+	acks   map[string]*ackEntry           // ackID -> 等待项
+	owners map[string]map[string]struct{} // owner（连接 ID）-> 该连接上等待中的 ackID
+	ttl    time.Duration
 }
 
-var AckManager = NewAckManager(5 * time.Second)
+// DefaultAckTimeout 默认的 ack 等待时间
+const DefaultAckTimeout = 5 * time.Second
 
+var AckManager = NewAckManager(DefaultAckTimeout)
+
+// NewAckManager 创建 ack 管理器，ttl 为 Wait 在 ctx 没有截止时间时的最长等待时间
 func NewAckManager(ttl time.Duration) *ackManager {
 	return &ackManager{
-		acks: make(map[string]*ackEntry),
-		ttl:  ttl,
+		acks:   make(map[string]*ackEntry),
+		owners: make(map[string]map[string]struct{}),
+		ttl:    ttl,
 	}
 }
 
-// Wait 生成一个 ackID 并阻塞等待 ack 返回，超时返回错误
-func (m *ackManager) Wait() (string, error) {
-	ackID := uuid.NewString()
-
+// Register 在发送消息之前注册 ackID，owner 为接收方的连接 ID，只有该连接回复的 ack 才有效
+// 返回的 channel 交给 Wait；ack 在 Wait 之前到达时也会保存在其中
+func (m *ackManager) Register(owner, ackID string) <-chan *Message {
 	m.mu.Lock()
-	entry := &ackEntry{ch: make(chan struct{})}
+	defer m.mu.Unlock()
+
+	entry := &ackEntry{owner: owner, ch: make(chan *Message, 1)}
 	m.acks[ackID] = entry
+	ids, ok := m.owners[owner]
+	if !ok {
+		ids = make(map[string]struct{})
+		m.owners[owner] = ids
+	}
+	ids[ackID] = struct{}{}
+	return entry.ch
+}
+
+// Wait 阻塞等待 Register 返回的 ch 并返回 ack 消息
+// ctx 没有设置截止时间时最多等待 ttl；超时、ctx 取消或连接关闭时返回错误并清理等待项
+func (m *ackManager) Wait(ctx context.Context, ackID string, ch <-chan *Message) (*Message, error) {
+	m.mu.Lock()
+	ttl := m.ttl
 	m.mu.Unlock()
 
-	timer := time.NewTimer(m.ttl)
-	defer timer.Stop()
+	if _, has := ctx.Deadline(); !has {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, ttl)
+		defer cancel()
+	}
 
 	select {
-	case <-entry.ch:
-		return ackID, nil
-	case <-timer.C:
-		m.mu.Lock()
-		delete(m.acks, ackID)
-		m.mu.Unlock()
-		return "", errors.New("ack timeout")
+	case msg, ok := <-ch:
+		if !ok {
+			return nil, ErrAckCanceled
+		}
+		return msg, nil
+	case <-ctx.Done():
+		m.Cancel(ackID)
+		// Cancel 之前 ack 可能刚好到达
+		if msg, ok := <-ch; ok {
+			return msg, nil
+		}
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, ErrAckTimeout
+		}
+		return nil, ctx.Err()
 	}
 }
 
-// Receive 表示收到了 ack，释放等待的协程
-func (m *ackManager) Receive(ackID string) {
+// Receive 表示收到了 ack，释放等待的协程；ackID 未注册或 owner 不匹配时返回 false
+func (m *ackManager) Receive(owner string, msg *Message) bool {
 	m.mu.Lock()
-	entry, ok := m.acks[ackID]
+	entry, ok := m.acks[msg.AckID]
+	if ok && entry.owner != owner {
+		ok = false
+	}
 	if ok {
-		delete(m.acks, ackID)
+		m.removeLocked(msg.AckID, entry)
 	}
 	m.mu.Unlock()
 
 	if ok {
-		entry.once.Do(func() {
-			close(entry.ch)
-		})
+		entry.ch <- msg
+	}
+	return ok
+}
+
+// Cancel 取消等待
+func (m *ackManager) Cancel(ackID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if entry, ok := m.acks[ackID]; ok {
+		m.removeLocked(ackID, entry)
+		close(entry.ch)
+	}
+}
+
+// CancelOwner 连接关闭时取消该连接上所有等待中的 ack
+func (m *ackManager) CancelOwner(owner string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for ackID := range m.owners[owner] {
+		entry := m.acks[ackID]
+		m.removeLocked(ackID, entry)
+		close(entry.ch)
+	}
+}
+
+// SetTTL 修改默认的等待时间，只影响之后调用的 Wait
+func (m *ackManager) SetTTL(ttl time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ttl = ttl
+}
+
+// Pending 当前等待中的 ack 数
+func (m *ackManager) Pending() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.acks)
+}
+
+func (m *ackManager) removeLocked(ackID string, entry *ackEntry) {
+	delete(m.acks, ackID)
+	ids := m.owners[entry.owner]
+	delete(ids, ackID)
+	if len(ids) == 0 {
+		delete(m.owners, entry.owner)
 	}
 }
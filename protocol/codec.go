@@ -0,0 +1,85 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// 握手时通过 Sec-WebSocket-Protocol 协商的子协议名
+const (
+	SubprotocolJSON     = "wssrv.json.v1"
+	SubprotocolProtobuf = "wssrv.proto.v1"
+)
+
+// Codec 消息的编解码格式，每个连接在握手时选定一种
+type Codec interface {
+	// Name 对应的子协议名
+	Name() string
+	// Binary 是否使用二进制帧发送
+	Binary() bool
+	Marshal(msg *Message) ([]byte, error)
+	// Unmarshal 解码一条消息，缺少 event 或 data 不是合法的 JSON 时返回错误
+	Unmarshal(data []byte) (*Message, error)
+}
+
+var (
+	// JSON 默认格式，文本帧
+	JSON Codec = jsonCodec{}
+	// Protobuf 二进制帧，定义见 api/proto/message.proto
+	Protobuf Codec = protobufCodec{}
+)
+
+var errMissingEvent = errors.New("missing event")
+
+// CodecFor 按子协议名查找编解码器
+func CodecFor(subprotocol string) (Codec, bool) {
+	switch subprotocol {
+	case SubprotocolJSON:
+		return JSON, true
+	case SubprotocolProtobuf:
+		return Protobuf, true
+	}
+	return nil, false
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return SubprotocolJSON }
+
+func (jsonCodec) Binary() bool { return false }
+
+func (jsonCodec) Marshal(msg *Message) ([]byte, error) { return json.Marshal(msg) }
+
+func (jsonCodec) Unmarshal(data []byte) (*Message, error) {
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, err
+	}
+	if msg.Event == "" {
+		return nil, errMissingEvent
+	}
+	return &msg, nil
+}
+
+type protobufCodec struct{}
+
+func (protobufCodec) Name() string { return SubprotocolProtobuf }
+
+func (protobufCodec) Binary() bool { return true }
+
+func (protobufCodec) Marshal(msg *Message) ([]byte, error) { return MarshalProto(msg) }
+
+func (protobufCodec) Unmarshal(data []byte) (*Message, error) {
+	msg, err := UnmarshalProto(data)
+	if err != nil {
+		return nil, err
+	}
+	if msg.Event == "" {
+		return nil, errMissingEvent
+	}
+	// data 会原样转给 JSON 连接、写入离线队列，必须是合法的 JSON
+	if len(msg.Data) > 0 && !json.Valid(msg.Data) {
+		return nil, NewError(CodeBadRequest, "data is not valid JSON")
+	}
+	return msg, nil
+}
@@ -0,0 +1,33 @@
+package protocol
+
+import "fmt"
+
+// EventError 事件处理失败且请求没有要求 ack 时，服务端回复的事件，Data 为 Error
+const EventError = "error"
+
+// 错误码，客户端据此区分错误类型，Message 只用于展示
+const (
+	CodeBadRequest   = "bad_request"       // 消息格式或参数错误
+	CodeValidation   = "validation_failed" // 参数未通过校验，Fields 给出失败的字段
+	CodeUnknownEvent = "unknown_event"     // 命名空间内没有注册该事件
+	CodeForbidden    = "forbidden"         // 无权执行该操作
+	CodeInternal     = "internal"          // 服务端内部错误
+)
+
+// Error 回复给客户端的结构化错误
+// 请求了 ack 时以 {"error": Error} 作为 ack 的内容回复，否则以 error 事件发送
+type Error struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Event   string            `json:"event,omitempty"`  // 处理失败的事件
+	Fields  map[string]string `json:"fields,omitempty"` // 校验失败的字段 -> 规则
+}
+
+// NewError 创建一个结构化错误
+func NewError(code, format string, args ...any) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Message
+}
@@ -0,0 +1,64 @@
+package protocol
+
+//go:generate protoc --proto_path=../api/proto --go_out=pb --go_opt=paths=source_relative message.proto
+
+import (
+	"encoding/json"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/focusandinsist/go-ws-srv/protocol/pb"
+)
+
+// ToProto 转换为 api/proto/message.proto 生成的 Message
+func ToProto(msg *Message) *pb.Message {
+	return &pb.Message{
+		Id:         msg.ID,
+		Event:      msg.Event,
+		Namespace:  msg.Namespace,
+		Ack:        msg.Ack,
+		AckId:      msg.AckID,
+		SenderId:   msg.SenderID,
+		ReceiverId: msg.ReceiverID,
+		Room:       msg.Room,
+		ConvId:     msg.ConvID,
+		Seq:        msg.Seq,
+		Data:       msg.Data,
+		Ts:         msg.Timestamp,
+	}
+}
+
+// FromProto 从生成的 Message 转换，data 为空时保持为 nil
+func FromProto(m *pb.Message) *Message {
+	msg := &Message{
+		ID:         m.GetId(),
+		Event:      m.GetEvent(),
+		Namespace:  m.GetNamespace(),
+		Ack:        m.GetAck(),
+		AckID:      m.GetAckId(),
+		SenderID:   m.GetSenderId(),
+		ReceiverID: m.GetReceiverId(),
+		Room:       m.GetRoom(),
+		ConvID:     m.GetConvId(),
+		Seq:        m.GetSeq(),
+		Timestamp:  m.GetTs(),
+	}
+	if len(m.GetData()) > 0 {
+		msg.Data = json.RawMessage(m.GetData())
+	}
+	return msg
+}
+
+// MarshalProto 按 Protobuf 编码消息
+func MarshalProto(msg *Message) ([]byte, error) {
+	return proto.Marshal(ToProto(msg))
+}
+
+// UnmarshalProto 解码 Protobuf 编码的消息，忽略未知字段
+func UnmarshalProto(b []byte) (*Message, error) {
+	var m pb.Message
+	if err := proto.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	return FromProto(&m), nil
+}
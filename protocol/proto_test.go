@@ -0,0 +1,174 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/focusandinsist/go-ws-srv/protocol/pb"
+)
+
+// fullMessage 每个字段都有非零值的消息
+func fullMessage() *Message {
+	return &Message{
+		ID:         "msg-1",
+		Event:      "chat",
+		Namespace:  "/chat",
+		Ack:        true,
+		AckID:      "ack-1",
+		SenderID:   "alice",
+		ReceiverID: "bob",
+		Room:       "lobby",
+		ConvID:     "dm:/chat:alice:bob",
+		Seq:        1 << 40,
+		Timestamp:  1760000000000,
+		Data:       json.RawMessage(`{"text":"hi"}`),
+	}
+}
+
+// fullProto fullMessage 对应的生成类型
+func fullProto() *pb.Message {
+	return &pb.Message{
+		Id:         "msg-1",
+		Event:      "chat",
+		Namespace:  "/chat",
+		Ack:        true,
+		AckId:      "ack-1",
+		SenderId:   "alice",
+		ReceiverId: "bob",
+		Room:       "lobby",
+		ConvId:     "dm:/chat:alice:bob",
+		Seq:        1 << 40,
+		Data:       []byte(`{"text":"hi"}`),
+		Ts:         1760000000000,
+	}
+}
+
+func TestProtoRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  *Message
+	}{
+		{"all fields", fullMessage()},
+		{"event only", &Message{Event: "ping"}},
+		{"negative seq", &Message{Event: "chat", Seq: -1}},
+		{"empty", &Message{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := MarshalProto(tt.msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := UnmarshalProto(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.msg) {
+				t.Fatalf("round trip = %+v, want %+v", got, tt.msg)
+			}
+		})
+	}
+}
+
+// TestProtoConversion 每个字段都与生成类型中的对应字段互相转换
+func TestProtoConversion(t *testing.T) {
+	if got, want := ToProto(fullMessage()), fullProto(); !proto.Equal(got, want) {
+		t.Fatalf("ToProto = %v, want %v", got, want)
+	}
+	if got, want := FromProto(fullProto()), fullMessage(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("FromProto = %+v, want %+v", got, want)
+	}
+}
+
+// 以下测试直接构造字节，字段号见 message.proto：1 id、2 event、4 ack、10 seq
+func TestUnmarshalProtoMalformed(t *testing.T) {
+	full, err := MarshalProto(fullMessage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		b    []byte
+	}{
+		{"truncated", full[:len(full)-1]},
+		{"truncated tag", []byte{0x80}},
+		{"length past end", append(protowire.AppendTag(nil, 2, protowire.BytesType), 10, 'c')},
+		{"truncated varint", append(protowire.AppendTag(nil, 10, protowire.VarintType), 0x80)},
+		{"invalid wire type", []byte{2<<3 | 6}},
+		{"field number zero", protowire.AppendVarint(protowire.AppendTag(nil, 0, protowire.VarintType), 1)},
+		{"invalid utf-8", protowire.AppendString(protowire.AppendTag(nil, 2, protowire.BytesType), "\xff")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if msg, err := UnmarshalProto(tt.b); err == nil {
+				t.Fatalf("UnmarshalProto(%x) = %+v, want error", tt.b, msg)
+			}
+		})
+	}
+}
+
+// TestUnmarshalProtoWrongWireType 已知字段使用了不同的编码类型时作为未知字段跳过
+func TestUnmarshalProtoWrongWireType(t *testing.T) {
+	b := protowire.AppendString(protowire.AppendTag(nil, 2, protowire.BytesType), "chat")
+	b = protowire.AppendVarint(protowire.AppendTag(b, 1, protowire.VarintType), 7)
+	b = protowire.AppendBytes(protowire.AppendTag(b, 10, protowire.BytesType), []byte("x"))
+	b = protowire.AppendFixed32(protowire.AppendTag(b, 4, protowire.Fixed32Type), 1)
+	b = protowire.AppendVarint(protowire.AppendTag(b, 99, protowire.VarintType), 1)
+
+	got, err := UnmarshalProto(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (&Message{Event: "chat"}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("UnmarshalProto = %+v, want %+v", got, want)
+	}
+}
+
+func TestProtobufCodecUnmarshal(t *testing.T) {
+	tests := []struct {
+		name    string
+		msg     *Message
+		wantErr bool
+	}{
+		{"valid", fullMessage(), false},
+		{"no data", &Message{Event: "chat"}, false},
+		{"missing event", &Message{Data: json.RawMessage(`{}`)}, true},
+		{"data not json", &Message{Event: "chat", Data: json.RawMessage("hi")}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := Protobuf.Marshal(tt.msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := Protobuf.Unmarshal(b)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Unmarshal = %+v, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.msg) {
+				t.Fatalf("Unmarshal = %+v, want %+v", got, tt.msg)
+			}
+		})
+	}
+
+	var perr *Error
+	b, err := MarshalProto(&Message{Event: "chat", Data: json.RawMessage("hi")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Protobuf.Unmarshal(b)
+	if !errors.As(err, &perr) || perr.Code != CodeBadRequest {
+		t.Fatalf("invalid JSON data error = %v, want %s", err, CodeBadRequest)
+	}
+}
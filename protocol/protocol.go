@@ -2,41 +2,66 @@ package protocol
 
 import (
 	"encoding/json"
-	"errors"
 )
 
+// EventPush 通过 REST 推送的原始消息发给 Protobuf 连接时使用的事件，Data 为推送内容的 JSON 字符串
+const EventPush = "push"
+
 type Message struct {
-	Event      string          `json:"event"`
-	Namespace  string          `json:"namespace,omitempty"` // 可选
-	Ack        bool            `json:"ack,omitempty"`
-	AckID      string          `json:"ack_id,omitempty"` // 用于确认机制
-	SenderID   string          `json:"sender_id,omitempty"`
-	ReceiverID string          `json:"receiver_id,omitempty"`
-	Data       json.RawMessage `json:"data"`
+	ID         string          `json:"id,omitempty" bson:"id,omitempty"` // 消息 ID，服务端分配，重发时不变，客户端据此去重
+	Event      string          `json:"event" bson:"event"`
+	Namespace  string          `json:"namespace,omitempty" bson:"namespace,omitempty"` // 可选
+	Ack        bool            `json:"ack,omitempty" bson:"ack,omitempty"`
+	AckID      string          `json:"ack_id,omitempty" bson:"ack_id,omitempty"`       // 用于确认机制
+	SenderID   string          `json:"sender_id,omitempty" bson:"sender_id,omitempty"` // 服务端按认证身份填写
+	ReceiverID string          `json:"receiver_id,omitempty" bson:"receiver_id,omitempty"`
+	Room       string          `json:"room,omitempty" bson:"room,omitempty"`       // 房间消息、join/leave 的目标房间
+	ConvID     string          `json:"conv_id,omitempty" bson:"conv_id,omitempty"` // 所属会话，见 ConversationID
+	Seq        int64           `json:"seq,omitempty" bson:"seq,omitempty"`         // 会话内单调递增的序号，服务端写入时分配
+	Timestamp  int64           `json:"ts,omitempty" bson:"ts,omitempty"`           // 服务端收到消息的时间，Unix 毫秒
+	Data       json.RawMessage `json:"data" bson:"data"`
 }
 
 func Encode(event string, data any, ack bool, ackID string) ([]byte, error) {
-	raw, err := json.Marshal(data)
+	msg, err := NewMessage(event, data)
 	if err != nil {
 		return nil, err
 	}
-	msg := Message{
-		Event: event,
-		Data:  raw,
-		Ack:   ack,
-		AckID: ackID,
-	}
+	msg.Ack = ack
+	msg.AckID = ackID
 	return json.Marshal(msg)
 }
 
-func Decode(input []byte) (*Message, error) {
-	var msg Message
-	err := json.Unmarshal(input, &msg)
+// NewMessage 创建一条消息，data 会被编码为 JSON
+func NewMessage(event string, data any) (*Message, error) {
+	raw, err := json.Marshal(data)
 	if err != nil {
 		return nil, err
 	}
-	if msg.Event == "" {
-		return nil, errors.New("missing event")
+	return &Message{Event: event, Data: raw}, nil
+}
+
+// Decode 解码 JSON 格式的消息，其他格式使用 Codec
+func Decode(input []byte) (*Message, error) {
+	return JSON.Unmarshal(input)
+}
+
+// EncodeMessage 以 JSON 格式编码完整的消息，其他格式使用 Codec
+func EncodeMessage(msg *Message) ([]byte, error) {
+	return JSON.Marshal(msg)
+}
+
+// ConversationID 计算会话 ID：房间消息为 room:<namespace>:<room>，单聊为 dm:<namespace>:<较小的用户 ID>:<较大的用户 ID>
+// 两者都为空时返回空字符串，表示不属于任何会话（如广播）
+func ConversationID(namespace, room, userA, userB string) string {
+	if room != "" {
+		return "room:" + namespace + ":" + room
+	}
+	if userB == "" {
+		return ""
+	}
+	if userA > userB {
+		userA, userB = userB, userA
 	}
-	return &msg, nil
+	return "dm:" + namespace + ":" + userA + ":" + userB
 }
@@ -4,48 +4,75 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"net/http"
+	"os"
 	"time"
 
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/gorilla/websocket"
 )
 
-// Message 代表 WebSocket 消息
+// Message 代表 WebSocket 消息，字段与 protocol.Message 一致
 type Message struct {
-	Type     string `json:"type"`        // 消息类型
-	SenderID string `json:"sender_id"`   // 发送者 ID
-	Receiver string `json:"receiver_id"` // 接收者 ID（可选）
-	RoomID   string `json:"room_id"`     // 房间 ID（可选）
-	Data     string `json:"data"`        // 消息内容
-	AckID    string `json:"ack_id"`      // ACK ID（可选，用于接收时回传 ACK）
+	ID       string `json:"id,omitempty"`          // 消息 ID，由服务端分配，重发的消息 ID 不变
+	Event    string `json:"event"`                 // 事件类型
+	SenderID string `json:"sender_id,omitempty"`   // 发送者 ID，由服务端填写，发送时留空
+	Receiver string `json:"receiver_id,omitempty"` // 接收者 ID（可选）
+	Room     string `json:"room,omitempty"`        // 房间（可选）
+	ConvID   string `json:"conv_id,omitempty"`     // 所属会话，由服务端填写
+	Seq      int64  `json:"seq,omitempty"`         // 会话内序号，由服务端填写
+	TS       int64  `json:"ts,omitempty"`          // 服务端时间（Unix 毫秒），由服务端填写
+	Data     any    `json:"data"`                  // 消息内容
+	Ack      bool   `json:"ack,omitempty"`         // 是否需要对方确认
+	AckID    string `json:"ack_id,omitempty"`      // ACK ID（可选，用于接收时回传 ACK）
+}
+
+// signToken 用环境变量 WSSRV_AUTH_SECRET 中的密钥签发一个 token，服务端需要使用同一个密钥
+func signToken(userID string) string {
+	secret := os.Getenv("WSSRV_AUTH_SECRET")
+	if secret == "" {
+		log.Fatal("WSSRV_AUTH_SECRET is not set")
+	}
+	claims := jwt.RegisteredClaims{
+		Subject:   userID,
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	if err != nil {
+		log.Fatal("Sign token failed:", err)
+	}
+	return token
 }
 
 func main() {
 	serverURL := "ws://localhost:8080/ws" // WebSocket 服务器地址
-	conn, _, err := websocket.DefaultDialer.Dial(serverURL, nil)
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+signToken("LHM"))
+	conn, _, err := websocket.DefaultDialer.Dial(serverURL, header)
 	if err != nil {
 		log.Fatal("Dial failed:", err)
 	}
 	defer conn.Close()
 
-	// 初始发送一条消息
-	msg := &Message{
-		Type:     "broadcast",
-		SenderID: "LHM",
-		RoomID:   "123",
-		Data:     "Hello from client",
-	}
-	data, err := json.Marshal(msg)
-	if err != nil {
-		fmt.Println("JSON 编码失败:", err)
-		return
-	}
-	err = conn.WriteMessage(websocket.TextMessage, data)
-	if err != nil {
-		log.Fatal("Write failed:", err)
+	// 先加入房间，再发送一条广播
+	for _, msg := range []*Message{
+		{Event: "join", Room: "123"},
+		{Event: "broadcast", Data: "Hello from client"},
+	} {
+		data, err := json.Marshal(msg)
+		if err != nil {
+			fmt.Println("JSON 编码失败:", err)
+			return
+		}
+		err = conn.WriteMessage(websocket.TextMessage, data)
+		if err != nil {
+			log.Fatal("Write failed:", err)
+		}
 	}
 
-	// 接收消息 + 自动 ACK
+	// 接收消息 + 自动 ACK，按消息 ID 去重
 	go func() {
+		seen := make(map[string]bool)
 		for {
 			_, msgBytes, err := conn.ReadMessage()
 			if err != nil {
@@ -60,12 +87,16 @@ func main() {
 				continue
 			}
 
+			// 服务端对我们请求的回复，不需要再确认
+			if incoming.Event == "__ack__" {
+				continue
+			}
+
 			// 如果包含 ack_id，自动回 ACK
 			if incoming.AckID != "" {
 				ackMsg := &Message{
-					Type:     "__ack__",
-					SenderID: "LHM",          // 用同一个 sender
-					AckID:    incoming.AckID, // 原封不动回去
+					Event: "__ack__",
+					AckID: incoming.AckID, // 原封不动回去
 				}
 				ackBytes, _ := json.Marshal(ackMsg)
 				err := conn.WriteMessage(websocket.TextMessage, ackBytes)
@@ -75,6 +106,18 @@ func main() {
 					fmt.Printf("发送 ACK: %s\n", incoming.AckID)
 				}
 			}
+
+			// 服务端没收到 ACK 时会重发，同一条消息只处理一次
+			if incoming.ID != "" {
+				if seen[incoming.ID] {
+					fmt.Printf("忽略重复消息: %s\n", incoming.ID)
+					continue
+				}
+				seen[incoming.ID] = true
+			}
+			if incoming.SenderID != "" {
+				fmt.Printf("[%s] %s at %s: %v\n", incoming.Event, incoming.SenderID, time.UnixMilli(incoming.TS).Format(time.TimeOnly), incoming.Data)
+			}
 		}
 	}()
 
@@ -83,10 +126,9 @@ func main() {
 		time.Sleep(5 * time.Second)
 
 		pingMsg := &Message{
-			Type:     "broadcast",
-			SenderID: "LHM",
-			RoomID:   "123",
-			Data:     "Ping",
+			Event: "room",
+			Room:  "123",
+			Data:  "Ping",
 		}
 		data, err := json.Marshal(pingMsg)
 		if err != nil {